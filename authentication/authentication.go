@@ -1,7 +1,55 @@
 package authentication
 
-import "k8s.io/client-go/pkg/apis/authentication"
+import (
+	"context"
+
+	"k8s.io/client-go/pkg/apis/authentication"
+)
 
 type Provider interface {
 	Lookup(token string) (*authentication.UserInfo, error)
 }
+
+// ContextProvider is a Provider whose lookups stop when the context is done.
+type ContextProvider interface {
+	Provider
+	LookupContext(ctx context.Context, token string) (*authentication.UserInfo, error)
+}
+
+// LookupContext looks the token up with the provider, giving up when the
+// context is done. Providers that do not implement ContextProvider keep
+// running in the background after the lookup has been given up.
+func LookupContext(ctx context.Context, provider Provider, token string) (*authentication.UserInfo, error) {
+	if contextProvider, ok := provider.(ContextProvider); ok {
+		return contextProvider.LookupContext(ctx, token)
+	}
+	if err := ContextError(ctx); err != nil {
+		return nil, err
+	}
+
+	type result struct {
+		userInfo *authentication.UserInfo
+		err      error
+	}
+	results := make(chan result, 1)
+	go func() {
+		userInfo, err := provider.Lookup(token)
+		results <- result{userInfo, err}
+	}()
+
+	select {
+	case r := <-results:
+		return r.userInfo, r.err
+	case <-ctx.Done():
+		return nil, ContextError(ctx)
+	}
+}
+
+// ContextError returns an UnavailableError once the context is done, the
+// token could not be checked in time.
+func ContextError(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return NewUnavailableError("lookup", err)
+	}
+	return nil
+}
@@ -0,0 +1,265 @@
+package bootstrapauthentication
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ghodss/yaml"
+	"github.com/rancher/kubernetes-auth/authentication"
+	"github.com/rancher/kubernetes-auth/filewatch"
+	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
+)
+
+const (
+	defaultUser    = "bootstrap"
+	reloadInterval = 10 * time.Second
+)
+
+// Options lists where bootstrap tokens come from. Tokens without their own
+// user and groups are given User and Groups.
+type Options struct {
+	// Token is a single token that never expires, as read from stdin. With
+	// a KeyFile it is taken to be the initial key file token instead, and
+	// is rotated out with it.
+	Token string
+	// Path is a file, or a directory of files, of tokens. YAML or JSON files
+	// list tokens with attributes, other files hold one token per line.
+	Path string
+	// KeyFile is a file whose hex encoded sha256 sum is a token
+	KeyFile string
+	User    string
+	Groups  []string
+	// RotationGrace is how long a token stays valid after it disappears
+	// from Path or KeyFile changes
+	RotationGrace time.Duration
+}
+
+// Token is a single entry of a YAML or JSON bootstrap token file.
+type Token struct {
+	Token   string     `json:"token"`
+	User    string     `json:"user,omitempty"`
+	Groups  []string   `json:"groups,omitempty"`
+	Expires *time.Time `json:"expires,omitempty"`
+}
+
+type tokenFile struct {
+	Tokens []Token `json:"tokens"`
+}
+
+type entry struct {
+	hash     [sha256.Size]byte
+	userInfo k8sAuthentication.UserInfo
+	// expires is zero for tokens that never expire
+	expires time.Time
+}
+
+// Provider authenticates bootstrap tokens. Files are reloaded when they
+// change or on SIGHUP, and tokens that disappear in a reload remain valid
+// for the rotation grace period, so old and new tokens overlap.
+type Provider struct {
+	sync.RWMutex
+	opts    Options
+	entries []entry
+}
+
+func NewProvider(opts Options) (*Provider, error) {
+	if opts.User == "" {
+		opts.User = defaultUser
+	}
+
+	p := &Provider{
+		opts: opts,
+	}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+	if opts.KeyFile != "" {
+		p.opts.Token = ""
+	}
+
+	for _, path := range []string{opts.Path, opts.KeyFile} {
+		if path == "" {
+			continue
+		}
+		go filewatch.Watch(path, reloadInterval, func() {
+			if err := p.load(); err != nil {
+				log.Errorf("Failed to reload bootstrap tokens, keeping previous tokens: %v", err)
+			}
+		})
+	}
+
+	return p, nil
+}
+
+// Lookup compares the token against every entry in constant time.
+func (p *Provider) Lookup(token string) (*k8sAuthentication.UserInfo, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	hash := sha256.Sum256([]byte(token))
+
+	p.RLock()
+	defer p.RUnlock()
+
+	var match *entry
+	for i := range p.entries {
+		if subtle.ConstantTimeCompare(hash[:], p.entries[i].hash[:]) == 1 && match == nil {
+			match = &p.entries[i]
+		}
+	}
+	if match == nil {
+		return nil, nil
+	}
+	if !match.expires.IsZero() && time.Now().After(match.expires) {
+		return nil, authentication.NewInvalidTokenError("bootstrap token of %s expired at %s", match.userInfo.Username, match.expires.Format(time.RFC3339))
+	}
+
+	log.Debugf("Authenticated bootstrap token of %s", match.userInfo.Username)
+	userInfo := match.userInfo
+	return &userInfo, nil
+}
+
+func (p *Provider) load() error {
+	var tokens []Token
+	if p.opts.Token != "" {
+		tokens = append(tokens, Token{Token: p.opts.Token})
+	}
+	if p.opts.Path != "" {
+		pathTokens, err := readPath(p.opts.Path)
+		if err != nil {
+			return err
+		}
+		tokens = append(tokens, pathTokens...)
+	}
+	if p.opts.KeyFile != "" {
+		key, err := ioutil.ReadFile(p.opts.KeyFile)
+		if err != nil {
+			return err
+		}
+		sum := sha256.Sum256(key)
+		tokens = append(tokens, Token{Token: hex.EncodeToString(sum[:])})
+	}
+
+	now := time.Now()
+	entries := make([]entry, 0, len(tokens))
+	current := map[[sha256.Size]byte]bool{}
+	for _, token := range tokens {
+		e := entry{
+			hash: sha256.Sum256([]byte(token.Token)),
+			userInfo: k8sAuthentication.UserInfo{
+				Username: token.User,
+				Groups:   token.Groups,
+			},
+		}
+		if e.userInfo.Username == "" {
+			e.userInfo.Username = p.opts.User
+		}
+		if e.userInfo.Groups == nil {
+			e.userInfo.Groups = p.opts.Groups
+		}
+		if token.Expires != nil {
+			e.expires = *token.Expires
+		}
+		entries = append(entries, e)
+		current[e.hash] = true
+	}
+
+	p.Lock()
+	defer p.Unlock()
+
+	retired := 0
+	for _, e := range p.entries {
+		if current[e.hash] {
+			continue
+		}
+		deadline := now.Add(p.opts.RotationGrace)
+		if e.expires.IsZero() || e.expires.After(deadline) {
+			e.expires = deadline
+		}
+		if e.expires.After(now) {
+			entries = append(entries, e)
+			retired++
+		}
+	}
+	p.entries = entries
+
+	log.Infof("Loaded %d bootstrap tokens, %d rotated out tokens remain valid for up to %s", len(entries)-retired, retired, p.opts.RotationGrace)
+	return nil
+}
+
+// readPath reads the tokens of a file, or of every file in a directory except
+// hidden ones such as the ..data links of Kubernetes secret volumes.
+func readPath(path string) ([]Token, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return readFile(path)
+	}
+
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return nil, err
+	}
+	var tokens []Token
+	for _, info := range infos {
+		name := filepath.Join(path, info.Name())
+		if strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		if target, err := os.Stat(name); err != nil || target.IsDir() {
+			continue
+		}
+		fileTokens, err := readFile(name)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, fileTokens...)
+	}
+	return tokens, nil
+}
+
+func readFile(path string) ([]Token, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml", ".json":
+		var file tokenFile
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("Failed to parse bootstrap tokens %s: %v", path, err)
+		}
+		for i, token := range file.Tokens {
+			if token.Token == "" {
+				return nil, fmt.Errorf("Bootstrap token %d in %s is empty", i+1, path)
+			}
+		}
+		return file.Tokens, nil
+	}
+
+	var tokens []Token
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		tokens = append(tokens, Token{Token: line})
+	}
+	return tokens, scanner.Err()
+}
@@ -0,0 +1,201 @@
+package bootstrapauthentication
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rancher/kubernetes-auth/authentication"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLookup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bootstrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	past := time.Now().Add(-time.Hour).UTC().Format(time.RFC3339)
+	future := time.Now().Add(time.Hour).UTC().Format(time.RFC3339)
+	writeFile(t, filepath.Join(dir, "tokens"), "# comment\nplain\n\n")
+	writeFile(t, filepath.Join(dir, "tokens.yaml"), `tokens:
+- token: named
+  user: operator
+  groups: [operators]
+  expires: `+future+`
+- token: expired
+  expires: `+past+`
+`)
+	writeFile(t, filepath.Join(dir, ".hidden"), "hidden\n")
+	keyFile := filepath.Join(dir, "..key")
+	writeFile(t, keyFile, "key")
+	keySum := sha256.Sum256([]byte("key"))
+
+	p, err := NewProvider(Options{
+		Token:   "stdin",
+		Path:    dir,
+		KeyFile: keyFile,
+		Groups:  []string{"system:masters"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		token    string
+		username string
+		group    string
+		invalid  bool
+	}{
+		{"stdin token", "stdin", defaultUser, "system:masters", false},
+		{"line token", "plain", defaultUser, "system:masters", false},
+		{"yaml token", "named", "operator", "operators", false},
+		{"key file token", hex.EncodeToString(keySum[:]), defaultUser, "system:masters", false},
+		{"expired token", "expired", "", "", true},
+		{"hidden file token", "hidden", "", "", false},
+		{"unknown token", "unknown", "", "", false},
+		{"token prefix", "plai", "", "", false},
+		{"empty token", "", "", "", false},
+	}
+
+	for _, test := range tests {
+		userInfo, err := p.Lookup(test.token)
+		switch {
+		case test.invalid != authentication.IsInvalidToken(err):
+			t.Errorf("%s: error %v, expected invalid token %v", test.name, err, test.invalid)
+		case !test.invalid && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.username == "" && userInfo != nil:
+			t.Errorf("%s: authenticated %+v", test.name, userInfo)
+		case test.username != "" && (userInfo == nil || userInfo.Username != test.username || len(userInfo.Groups) != 1 || userInfo.Groups[0] != test.group):
+			t.Errorf("%s: got %+v, expected %s in %s", test.name, userInfo, test.username, test.group)
+		}
+	}
+}
+
+func TestRotation(t *testing.T) {
+	tests := []struct {
+		name  string
+		grace time.Duration
+		valid bool
+	}{
+		{"within grace", time.Hour, true},
+		{"no grace", 0, false},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "bootstrap")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, "tokens")
+		writeFile(t, path, "old\n")
+
+		p, err := NewProvider(Options{Path: path, RotationGrace: test.grace})
+		if err != nil {
+			t.Fatal(err)
+		}
+		writeFile(t, path, "new\n")
+		if err := p.load(); err != nil {
+			t.Fatal(err)
+		}
+
+		if userInfo, err := p.Lookup("new"); err != nil || userInfo == nil {
+			t.Errorf("%s: new token not accepted: %v", test.name, err)
+		}
+		userInfo, err := p.Lookup("old")
+		if valid := err == nil && userInfo != nil; valid != test.valid {
+			t.Errorf("%s: old token valid %v, expected %v", test.name, valid, test.valid)
+		}
+
+		// a token that returns is current again and loses its deadline
+		writeFile(t, path, "new\nold\n")
+		if err := p.load(); err != nil {
+			t.Fatal(err)
+		}
+		if userInfo, err := p.Lookup("old"); err != nil || userInfo == nil {
+			t.Errorf("%s: restored token not accepted: %v", test.name, err)
+		}
+	}
+}
+
+func TestStdinTokenWithKeyFile(t *testing.T) {
+	keyToken := func(key string) string {
+		sum := sha256.Sum256([]byte(key))
+		return hex.EncodeToString(sum[:])
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"stdin token derived from the key", keyToken("key1")},
+		{"other stdin token", "stdin"},
+	}
+
+	for _, test := range tests {
+		dir, err := ioutil.TempDir("", "bootstrap")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(dir)
+		keyFile := filepath.Join(dir, "key.pem")
+		writeFile(t, keyFile, "key1")
+
+		p, err := NewProvider(Options{Token: test.token, KeyFile: keyFile})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if userInfo, err := p.Lookup(test.token); err != nil || userInfo == nil {
+			t.Errorf("%s: stdin token not accepted before rotation: %v", test.name, err)
+		}
+
+		writeFile(t, keyFile, "key2")
+		if err := p.load(); err != nil {
+			t.Fatal(err)
+		}
+		if userInfo, err := p.Lookup(keyToken("key2")); err != nil || userInfo == nil {
+			t.Errorf("%s: rotated key token not accepted: %v", test.name, err)
+		}
+		if userInfo, _ := p.Lookup(test.token); userInfo != nil {
+			t.Errorf("%s: stdin token still accepted after rotation", test.name)
+		}
+	}
+}
+
+func TestReadFileErrors(t *testing.T) {
+	dir, err := ioutil.TempDir("", "bootstrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{"invalid yaml", "tokens.yaml", "tokens: ["},
+		{"empty token", "tokens.json", `{"tokens":[{"user":"operator"}]}`},
+	}
+
+	for _, test := range tests {
+		path := filepath.Join(dir, test.file)
+		writeFile(t, path, test.data)
+		if _, err := NewProvider(Options{Path: path}); err == nil {
+			t.Errorf("%s: loaded", test.name)
+		}
+	}
+}
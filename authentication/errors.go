@@ -0,0 +1,52 @@
+package authentication
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+)
+
+// InvalidTokenError is returned by a Provider when a token was checked and
+// found to be malformed or not valid for any user.
+type InvalidTokenError struct {
+	Reason string
+}
+
+func (e *InvalidTokenError) Error() string {
+	return "Invalid token: " + e.Reason
+}
+
+// UnavailableError is returned by a Provider when the backend it relies on
+// could not be reached or answered with something unusable, so the token
+// could not be checked at all.
+type UnavailableError struct {
+	Backend string
+	Err     error
+}
+
+func (e *UnavailableError) Error() string {
+	return fmt.Sprintf("%s unavailable: %v", e.Backend, e.Err)
+}
+
+func NewInvalidTokenError(format string, args ...interface{}) error {
+	return &InvalidTokenError{
+		Reason: fmt.Sprintf(format, args...),
+	}
+}
+
+func NewUnavailableError(backend string, err error) error {
+	return &UnavailableError{
+		Backend: backend,
+		Err:     err,
+	}
+}
+
+func IsInvalidToken(err error) bool {
+	_, ok := errors.Cause(err).(*InvalidTokenError)
+	return ok
+}
+
+func IsUnavailable(err error) bool {
+	_, ok := errors.Cause(err).(*UnavailableError)
+	return ok
+}
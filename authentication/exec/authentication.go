@@ -0,0 +1,166 @@
+package execauthentication
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/kubernetes-auth/authentication"
+	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
+)
+
+const (
+	backendName = "exec"
+
+	defaultTimeout = 10 * time.Second
+
+	// maxStderr limits how much of a failed plugin's output is logged
+	maxStderr = 512
+)
+
+func init() {
+	authentication.Register("exec", newFromConfig)
+}
+
+type config struct {
+	Command string   `json:"command"`
+	Args    []string `json:"args,omitempty"`
+	Timeout string   `json:"timeout,omitempty"`
+}
+
+// Response is what the plugin writes to stdout. A token the plugin does not
+// know is answered with authenticated false, one it knows to be invalid with
+// an error as well.
+type Response struct {
+	Authenticated bool   `json:"authenticated"`
+	Error         string `json:"error,omitempty"`
+	User          *User  `json:"user,omitempty"`
+}
+
+type User struct {
+	Username string              `json:"username"`
+	UID      string              `json:"uid,omitempty"`
+	Groups   []string            `json:"groups,omitempty"`
+	Extra    map[string][]string `json:"extra,omitempty"`
+}
+
+// Provider authenticates tokens with an external plugin, run once per lookup
+// with the token on stdin. A plugin exiting with an error, or not answering
+// within the timeout, leaves the token unchecked.
+type Provider struct {
+	command string
+	args    []string
+	timeout time.Duration
+}
+
+func NewProvider(command string, args []string, timeout time.Duration) (*Provider, error) {
+	if command == "" {
+		return nil, fmt.Errorf("An exec plugin command is required")
+	}
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Provider{
+		command: command,
+		args:    args,
+		timeout: timeout,
+	}, nil
+}
+
+func newFromConfig(data []byte) (authentication.Provider, error) {
+	var cfg config
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, err
+		}
+	}
+	var timeout time.Duration
+	if cfg.Timeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(cfg.Timeout); err != nil {
+			return nil, fmt.Errorf("Invalid exec plugin timeout: %v", err)
+		}
+	}
+	return NewProvider(cfg.Command, cfg.Args, timeout)
+}
+
+func (p *Provider) Lookup(token string) (*k8sAuthentication.UserInfo, error) {
+	return p.LookupContext(context.Background(), token)
+}
+
+// LookupContext kills the plugin when the context is done.
+func (p *Provider) LookupContext(ctx context.Context, token string) (*k8sAuthentication.UserInfo, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, p.timeout)
+	defer cancel()
+
+	var stdout, stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, p.command, p.args...)
+	cmd.Stdin = strings.NewReader(token)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := cmd.Start(); err != nil {
+		return nil, authentication.NewUnavailableError(backendName, err)
+	}
+	// The plugin is killed once the context is done, but children it
+	// started may keep its output open, so waiting is given up as well
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+	var err error
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		return nil, authentication.ContextError(ctx)
+	}
+
+	if err != nil {
+		if ctxErr := authentication.ContextError(ctx); ctxErr != nil {
+			return nil, ctxErr
+		}
+		output := stderr.String()
+		if len(output) > maxStderr {
+			output = output[:maxStderr] + "..."
+		}
+		return nil, authentication.NewUnavailableError(backendName, fmt.Errorf("%s failed: %v: %s", p.command, err, strings.TrimSpace(output)))
+	}
+
+	var response Response
+	if err := json.Unmarshal(stdout.Bytes(), &response); err != nil {
+		return nil, authentication.NewUnavailableError(backendName, fmt.Errorf("Failed to parse response of %s: %v", p.command, err))
+	}
+
+	if !response.Authenticated {
+		if response.Error != "" {
+			return nil, authentication.NewInvalidTokenError("%s", response.Error)
+		}
+		return nil, nil
+	}
+	if response.User == nil || response.User.Username == "" {
+		return nil, authentication.NewUnavailableError(backendName, fmt.Errorf("%s authenticated a token without a username", p.command))
+	}
+
+	log.Debugf("Exec plugin %s authenticated %s", p.command, response.User.Username)
+	userInfo := &k8sAuthentication.UserInfo{
+		Username: response.User.Username,
+		UID:      response.User.UID,
+		Groups:   response.User.Groups,
+	}
+	if len(response.User.Extra) > 0 {
+		userInfo.Extra = map[string]k8sAuthentication.ExtraValue{}
+		for key, value := range response.User.Extra {
+			userInfo.Extra[key] = k8sAuthentication.ExtraValue(value)
+		}
+	}
+	return userInfo, nil
+}
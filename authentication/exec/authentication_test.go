@@ -0,0 +1,110 @@
+package execauthentication
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/rancher/kubernetes-auth/authentication"
+)
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name        string
+		script      string
+		username    string
+		invalid     bool
+		unavailable bool
+	}{
+		{
+			name:     "authenticated",
+			script:   `read token; [ "$token" = secret ] && echo '{"authenticated":true,"user":{"username":"user","groups":["group"],"extra":{"key":["value"]}}}'`,
+			username: "user",
+		},
+		{
+			name:   "unknown token",
+			script: `echo '{"authenticated":false}'`,
+		},
+		{
+			name:    "invalid token",
+			script:  `echo '{"authenticated":false,"error":"token revoked"}'`,
+			invalid: true,
+		},
+		{
+			name:        "plugin failure",
+			script:      `echo broken >&2; exit 1`,
+			unavailable: true,
+		},
+		{
+			name:        "not json",
+			script:      `echo authenticated`,
+			unavailable: true,
+		},
+		{
+			name:        "no username",
+			script:      `echo '{"authenticated":true,"user":{}}'`,
+			unavailable: true,
+		},
+		{
+			name:        "timeout",
+			script:      `sleep 5`,
+			unavailable: true,
+		},
+	}
+
+	for _, test := range tests {
+		p, err := NewProvider("sh", []string{"-c", test.script}, 200*time.Millisecond)
+		if err != nil {
+			t.Fatal(err)
+		}
+		userInfo, err := p.Lookup("secret")
+
+		switch {
+		case test.invalid != authentication.IsInvalidToken(err):
+			t.Errorf("%s: error %v, expected invalid token %v", test.name, err, test.invalid)
+		case test.unavailable != authentication.IsUnavailable(err):
+			t.Errorf("%s: error %v, expected unavailable %v", test.name, err, test.unavailable)
+		case test.username == "" && userInfo != nil:
+			t.Errorf("%s: authenticated %+v", test.name, userInfo)
+		case test.username != "" && (userInfo == nil || userInfo.Username != test.username):
+			t.Errorf("%s: got %+v, expected %s", test.name, userInfo, test.username)
+		}
+	}
+}
+
+func TestLookupContext(t *testing.T) {
+	p, err := NewProvider("sh", []string{"-c", "sleep 5"}, time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	if _, err := p.LookupContext(ctx, "secret"); !authentication.IsUnavailable(err) {
+		t.Errorf("error %v, expected unavailable", err)
+	}
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("plugin ran for %v after the context was done", elapsed)
+	}
+}
+
+func TestNewFromConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		valid  bool
+	}{
+		{"command", `{"command":"plugin"}`, true},
+		{"command with timeout", `{"command":"plugin","args":["-v"],"timeout":"2s"}`, true},
+		{"no command", `{}`, false},
+		{"invalid timeout", `{"command":"plugin","timeout":"soon"}`, false},
+	}
+
+	for _, test := range tests {
+		_, err := newFromConfig([]byte(test.config))
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("%s: error %v, expected valid %v", test.name, err, test.valid)
+		}
+	}
+}
@@ -0,0 +1,115 @@
+package rancherauthentication
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/kubernetes-auth/authentication"
+)
+
+const (
+	// ActiveState is the state of Rancher resources in use
+	ActiveState = "active"
+
+	adminKind      = "admin"
+	rancherIDType  = "rancher_id"
+	updatingActive = "updating-active"
+	accountsPath   = "/accounts"
+	apiKeysPath    = "/apikeys"
+)
+
+// isActive reports whether a resource is in a state in which it may be used.
+func isActive(state string) bool {
+	return state == ActiveState || state == updatingActive
+}
+
+// isNotFound reports whether get failed because the resource does not exist.
+func isNotFound(err error) bool {
+	if unavailable, ok := err.(*authentication.UnavailableError); ok {
+		return client.IsNotFound(unavailable.Err)
+	}
+	return false
+}
+
+// callerAccount returns the account the identities belong to, found through
+// the rancher_id identity linking to the account or else the account whose
+// identity is the user's identity. It returns nil if there is none.
+func (p *Provider) callerAccount(ctx context.Context, authorization string, collection client.IdentityCollection) (*client.Account, error) {
+	var userIdentity client.Identity
+	for _, identity := range collection.Data {
+		if !identity.User {
+			continue
+		}
+		if identity.ExternalIdType == rancherIDType {
+			var account client.Account
+			if err := p.get(ctx, accountsPath+"/"+url.PathEscape(identity.ExternalId), authorization, &account); err != nil && !isNotFound(err) {
+				return nil, err
+			}
+			if account.Id == identity.ExternalId {
+				return &account, nil
+			}
+			log.Debugf("Account %s of identity %s not found", identity.ExternalId, identity.Id)
+		} else if userIdentity.Id == "" {
+			userIdentity = identity
+		}
+	}
+	if userIdentity.Id == "" {
+		return nil, nil
+	}
+
+	var accounts client.AccountCollection
+	if err := p.get(ctx, accountsPath+"?identity="+url.QueryEscape(userIdentity.Id), authorization, &accounts); err != nil {
+		return nil, err
+	}
+	for _, account := range accounts.Data {
+		if account.Identity == userIdentity.Id {
+			return &account, nil
+		}
+	}
+	return nil, nil
+}
+
+// credentialDenial returns why the API key presented in the Authorization
+// header, or the account owning it, may not be used, or an empty string if
+// they may. Credentials other than API keys are not checked.
+func (p *Provider) credentialDenial(ctx context.Context, authorization string, caller *client.Account) (string, error) {
+	accessKey := apiKeyAccessKey(authorization)
+	if accessKey == "" {
+		return "", nil
+	}
+
+	var apiKeys client.ApiKeyCollection
+	if err := p.get(ctx, apiKeysPath+"?publicValue="+url.QueryEscape(accessKey), authorization, &apiKeys); err != nil {
+		return "", err
+	}
+	var apiKey *client.ApiKey
+	for i := range apiKeys.Data {
+		if apiKeys.Data[i].PublicValue == accessKey {
+			apiKey = &apiKeys.Data[i]
+		}
+	}
+	if apiKey == nil {
+		return fmt.Sprintf("API key %s not found", accessKey), nil
+	}
+	if !isActive(apiKey.State) {
+		return fmt.Sprintf("API key %s is %s", accessKey, apiKey.State), nil
+	}
+
+	if apiKey.AccountId == "" || apiKey.AccountId == caller.Id {
+		return "", nil
+	}
+	var owner client.Account
+	if err := p.get(ctx, accountsPath+"/"+url.PathEscape(apiKey.AccountId), authorization, &owner); err != nil && !isNotFound(err) {
+		return "", err
+	}
+	if owner.Id != apiKey.AccountId {
+		return fmt.Sprintf("account %s owning API key %s not found", apiKey.AccountId, accessKey), nil
+	}
+	if !isActive(owner.State) {
+		return fmt.Sprintf("account %s owning API key %s is %s", owner.Id, accessKey, owner.State), nil
+	}
+	return "", nil
+}
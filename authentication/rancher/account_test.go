@@ -0,0 +1,209 @@
+package rancherauthentication
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/go-rancher/v2"
+)
+
+// fakeAccounts serves the identities of the caller along with the accounts
+// and API keys of a Rancher server.
+type fakeAccounts struct {
+	identities []client.Identity
+	accounts   []client.Account
+	apiKeys    []client.ApiKey
+}
+
+func (f *fakeAccounts) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.TrimPrefix(r.URL.Path, "/v2-beta")
+	query := r.URL.Query()
+
+	var response interface{}
+	switch {
+	case path == "/settings/"+apiSecurityEnabledSetting:
+		response = client.Setting{Name: apiSecurityEnabledSetting, Value: "true"}
+	case path == "/identity":
+		response = client.IdentityCollection{Data: f.identities}
+	case path == accountsPath:
+		var accounts []client.Account
+		for _, account := range f.accounts {
+			if account.Identity == query.Get("identity") {
+				accounts = append(accounts, account)
+			}
+		}
+		response = client.AccountCollection{Data: accounts}
+	case strings.HasPrefix(path, accountsPath+"/"):
+		for _, account := range f.accounts {
+			if account.Id == strings.TrimPrefix(path, accountsPath+"/") {
+				response = account
+			}
+		}
+	case path == apiKeysPath:
+		var apiKeys []client.ApiKey
+		for _, apiKey := range f.apiKeys {
+			if apiKey.PublicValue == query.Get("publicValue") {
+				apiKeys = append(apiKeys, apiKey)
+			}
+		}
+		response = client.ApiKeyCollection{Data: apiKeys}
+	}
+
+	if response == nil {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// newAccountTestProvider returns a provider for environment 1a5, in which
+// ldap_user:jdoe is a member, backed by the fake accounts.
+func newAccountTestProvider(t *testing.T, accounts *fakeAccounts) (*Provider, func()) {
+	server, _ := newRancherServer(t, []client.ProjectMember{
+		{Resource: client.Resource{Id: "1pm1"}, ExternalIdType: "ldap_user", ExternalId: "jdoe", Role: "member"},
+	}, accounts.ServeHTTP)
+
+	rancherClient, err := client.NewRancherClient(&client.ClientOpts{Url: server.URL})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+	nameRules, err := compileNameRules(NameRules{})
+	if err != nil {
+		server.Close()
+		t.Fatal(err)
+	}
+
+	return &Provider{
+		url:           server.URL + "/v2-beta",
+		client:        rancherClient,
+		environmentID: "1a5",
+		adminGroup:    "admins",
+		roleGroups:    map[string][]string{"member": {"members"}},
+		nameRules:     nameRules,
+		httpClient:    http.DefaultClient,
+		lookupTimeout: time.Minute,
+	}, server.Close
+}
+
+var (
+	ldapIdentity = client.Identity{
+		Resource:       client.Resource{Id: "ldap_user:jdoe"},
+		User:           true,
+		Login:          "jdoe",
+		ExternalId:     "jdoe",
+		ExternalIdType: "ldap_user",
+	}
+	accountIdentity = client.Identity{
+		Resource:       client.Resource{Id: "rancher_id:1a7"},
+		User:           true,
+		Login:          "jdoe",
+		ExternalId:     "1a7",
+		ExternalIdType: rancherIDType,
+	}
+	activeKey = client.ApiKey{PublicValue: "access", AccountId: "1a7", State: ActiveState}
+)
+
+func account(kind, state string) client.Account {
+	return client.Account{
+		Resource: client.Resource{Id: "1a7"},
+		Kind:     kind,
+		Identity: "ldap_user:jdoe",
+		State:    state,
+	}
+}
+
+// lookupGroups looks the API key up and returns the groups of the user, or
+// nil if it is not authenticated.
+func lookupGroups(t *testing.T, name string, accounts *fakeAccounts) []string {
+	p, done := newAccountTestProvider(t, accounts)
+	defer done()
+
+	userInfo, err := p.Lookup(EncodeToken("access", "secret"))
+	if err != nil {
+		t.Errorf("%s: %v", name, err)
+		return nil
+	}
+	if userInfo == nil {
+		return nil
+	}
+	if userInfo.Username != "jdoe" {
+		t.Errorf("%s: authenticated as %s", name, userInfo.Username)
+	}
+	return userInfo.Groups
+}
+
+func TestLookupCallerAccount(t *testing.T) {
+	otherAdmin := account(adminKind, ActiveState)
+	otherAdmin.Id = "1a1"
+	otherAdmin.Identity = "ldap_user:root"
+
+	tests := []struct {
+		name       string
+		identities []client.Identity
+		accounts   []client.Account
+		groups     []string
+	}{
+		{"member", []client.Identity{ldapIdentity, accountIdentity}, []client.Account{account("user", ActiveState)}, []string{"members"}},
+		{"admin", []client.Identity{ldapIdentity, accountIdentity}, []client.Account{account(adminKind, ActiveState)}, []string{"admins"}},
+		{"admin updating", []client.Identity{ldapIdentity, accountIdentity}, []client.Account{account(adminKind, updatingActive)}, []string{"admins"}},
+		{"other account is admin", []client.Identity{ldapIdentity, accountIdentity}, []client.Account{account("user", ActiveState), otherAdmin}, []string{"members"}},
+		{"found by identity", []client.Identity{ldapIdentity}, []client.Account{account(adminKind, ActiveState)}, []string{"admins"}},
+		{"inactive account", []client.Identity{ldapIdentity, accountIdentity}, []client.Account{account("user", "inactive")}, nil},
+		{"inactive admin", []client.Identity{ldapIdentity, accountIdentity}, []client.Account{account(adminKind, "inactive")}, nil},
+		{"removed account", []client.Identity{ldapIdentity, accountIdentity}, []client.Account{account("user", "removed")}, nil},
+		{"purging account", []client.Identity{ldapIdentity, accountIdentity}, []client.Account{account("user", "purging")}, nil},
+		{"missing account", []client.Identity{ldapIdentity, accountIdentity}, nil, nil},
+		{"missing account found by identity", []client.Identity{ldapIdentity}, []client.Account{otherAdmin}, nil},
+	}
+
+	for _, test := range tests {
+		groups := lookupGroups(t, test.name, &fakeAccounts{
+			identities: test.identities,
+			accounts:   test.accounts,
+			apiKeys:    []client.ApiKey{activeKey},
+		})
+		if !reflect.DeepEqual(groups, test.groups) {
+			t.Errorf("%s: got groups %v, expected %v", test.name, groups, test.groups)
+		}
+	}
+}
+
+func TestLookupCredentialDenial(t *testing.T) {
+	owner := account("user", ActiveState)
+	owner.Id = "1a8"
+	owner.Identity = "ldap_user:automation"
+	inactiveOwner := owner
+	inactiveOwner.State = "inactive"
+
+	tests := []struct {
+		name     string
+		apiKeys  []client.ApiKey
+		accounts []client.Account
+		groups   []string
+	}{
+		{"active key", []client.ApiKey{activeKey}, nil, []string{"members"}},
+		{"key updating", []client.ApiKey{{PublicValue: "access", AccountId: "1a7", State: updatingActive}}, nil, []string{"members"}},
+		{"inactive key", []client.ApiKey{{PublicValue: "access", AccountId: "1a7", State: "inactive"}}, nil, nil},
+		{"removed key", []client.ApiKey{{PublicValue: "access", AccountId: "1a7", State: "removed"}}, nil, nil},
+		{"missing key", []client.ApiKey{{PublicValue: "other", AccountId: "1a7", State: ActiveState}}, nil, nil},
+		{"key of active account", []client.ApiKey{{PublicValue: "access", AccountId: "1a8", State: ActiveState}}, []client.Account{owner}, []string{"members"}},
+		{"key of inactive account", []client.ApiKey{{PublicValue: "access", AccountId: "1a8", State: ActiveState}}, []client.Account{inactiveOwner}, nil},
+		{"key of missing account", []client.ApiKey{{PublicValue: "access", AccountId: "1a8", State: ActiveState}}, nil, nil},
+	}
+
+	for _, test := range tests {
+		groups := lookupGroups(t, test.name, &fakeAccounts{
+			identities: []client.Identity{ldapIdentity, accountIdentity},
+			accounts:   append([]client.Account{account("user", ActiveState)}, test.accounts...),
+			apiKeys:    test.apiKeys,
+		})
+		if !reflect.DeepEqual(groups, test.groups) {
+			t.Errorf("%s: got groups %v, expected %v", test.name, groups, test.groups)
+		}
+	}
+}
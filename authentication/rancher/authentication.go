@@ -1,15 +1,20 @@
 package rancherauthentication
 
 import (
+	"context"
 	"encoding/base64"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/kubernetes-auth/authentication"
+	"github.com/rancher/kubernetes-auth/authorization"
 	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
 )
 
@@ -18,179 +23,540 @@ const (
 	cattleURLAccessKeyEnv = "CATTLE_ACCESS_KEY"
 	cattleURLSecretKeyEnv = "CATTLE_SECRET_KEY"
 
-	kubernetesMasterGroup = "system:masters"
-	adminUser             = "admin"
-	bootstrapUser         = "bootstrap"
+	backendName               = "Rancher"
+	apiSecurityEnabledSetting = "api.security.enabled"
+
+	defaultAdminGroup = "system:masters"
+	adminUser         = "admin"
+	anonymousUser     = "anonymous"
+
+	// AuthDisabledDeny rejects every token while access control is disabled
+	AuthDisabledDeny = "deny"
+	// AuthDisabledReadOnly authenticates every token as an anonymous user
+	// in AuthDisabledGroup
+	AuthDisabledReadOnly = "readonly"
+	// AuthDisabledAdmin authenticates every token as an admin
+	AuthDisabledAdmin = "admin"
+
+	defaultAuthDisabledGroup = "rancher:auth-disabled"
+
+	// maxErrorBody limits how much of a failed response is logged
+	maxErrorBody = 512
+
+	healthProbeTimeout = 5 * time.Second
+
+	defaultCacheSize        = 1024
+	defaultCacheTTL         = time.Minute
+	defaultCacheNegativeTTL = 10 * time.Second
+	defaultLookupTimeout    = 30 * time.Second
 )
 
+func init() {
+	authentication.Register("rancher", newFromConfig)
+}
+
+type Options struct {
+	// Environment is the ID, name or UUID of the environment whose members
+	// are authenticated, or MetadataEnvironment
+	Environment string
+	// AdminGroup is given to Rancher admins, and to everyone when access
+	// control is disabled with AuthDisabledAdmin
+	AdminGroup string
+	// RoleGroups maps environment roles to the groups members holding them
+	// get. Owners get AdminGroup unless mapped otherwise.
+	RoleGroups map[string][]string
+	Names      NameRules
+	// AuthDisabledPolicy decides how tokens are treated while Rancher has
+	// access control disabled, AuthDisabledDeny by default
+	AuthDisabledPolicy string
+	AuthDisabledGroup  string
+	CacheSize          int
+	CacheTTL           time.Duration
+	CacheNegativeTTL   time.Duration
+	// LookupTimeout bounds the Rancher requests of a lookup, 30s if unset
+	LookupTimeout time.Duration
+}
+
+// config is the configuration file form of Options, with durations as
+// strings such as "1m".
+type config struct {
+	Environment        string              `json:"environment"`
+	AdminGroup         string              `json:"adminGroup"`
+	RoleGroups         map[string][]string `json:"roleGroups"`
+	Names              NameRules           `json:"names"`
+	AuthDisabledPolicy string              `json:"authDisabledPolicy"`
+	AuthDisabledGroup  string              `json:"authDisabledGroup"`
+	CacheSize          int                 `json:"cacheSize"`
+	CacheTTL           string              `json:"cacheTTL"`
+	CacheNegativeTTL   string              `json:"cacheNegativeTTL"`
+	LookupTimeout      string              `json:"lookupTimeout"`
+}
+
 type Provider struct {
-	url            string
-	client         *client.RancherClient
-	bootstrapToken string
-	httpClient     *http.Client
+	url                string
+	client             *client.RancherClient
+	environmentID      string
+	adminGroup         string
+	roleGroups         map[string][]string
+	nameRules          *nameRules
+	authDisabledPolicy string
+	authDisabledGroup  string
+	health             healthStatus
+	httpClient         *http.Client
+	lookupTimeout      time.Duration
+	cache              *tokenCache
+	inflight           lookupGroup
+	signatureKey       []byte
 }
 
-func NewProvider(bootstrapToken string) (*Provider, error) {
+func NewProvider(opts Options) (*Provider, error) {
 	url, err := client.NormalizeUrl(os.Getenv(cattleURLEnv))
 	if err != nil {
 		return nil, err
 	}
+	lookupTimeout := opts.LookupTimeout
+	if lookupTimeout <= 0 {
+		lookupTimeout = defaultLookupTimeout
+	}
 	rancherClient, err := client.NewRancherClient(&client.ClientOpts{
 		Url:       url,
 		AccessKey: os.Getenv(cattleURLAccessKeyEnv),
 		SecretKey: os.Getenv(cattleURLSecretKeyEnv),
+		Timeout:   lookupTimeout,
 	})
+	if err != nil {
+		return nil, err
+	}
+	signatureKey, err := newSignatureKey(os.Getenv(cattleURLSecretKeyEnv))
+	if err != nil {
+		return nil, err
+	}
+
+	adminGroup := opts.AdminGroup
+	if adminGroup == "" {
+		adminGroup = defaultAdminGroup
+	}
+	roleGroups := map[string][]string{
+		ownerRole: {adminGroup},
+	}
+	for role, groups := range opts.RoleGroups {
+		if rolePriority(role) == len(authorization.Roles) {
+			return nil, fmt.Errorf("Unknown Rancher environment role %s, expected one of %s", role, strings.Join(authorization.Roles, ", "))
+		}
+		roleGroups[role] = groups
+	}
+
+	authDisabledPolicy := opts.AuthDisabledPolicy
+	switch authDisabledPolicy {
+	case "":
+		authDisabledPolicy = AuthDisabledDeny
+	case AuthDisabledDeny, AuthDisabledReadOnly, AuthDisabledAdmin:
+	default:
+		return nil, fmt.Errorf("Invalid auth disabled policy %s, expected %s, %s or %s", authDisabledPolicy,
+			AuthDisabledDeny, AuthDisabledReadOnly, AuthDisabledAdmin)
+	}
+	authDisabledGroup := opts.AuthDisabledGroup
+	if authDisabledGroup == "" {
+		authDisabledGroup = defaultAuthDisabledGroup
+	}
+
+	nameRules, err := compileNameRules(opts.Names)
+	if err != nil {
+		return nil, err
+	}
+
+	environmentID, err := resolveEnvironment(rancherClient, opts.Environment)
+	if err != nil {
+		return nil, err
+	}
+	log.Infof("Authenticating members of Rancher environment %s", environmentID)
+
 	return &Provider{
-		url:            url,
-		client:         rancherClient,
-		bootstrapToken: bootstrapToken,
+		url:                url,
+		client:             rancherClient,
+		environmentID:      environmentID,
+		adminGroup:         adminGroup,
+		roleGroups:         roleGroups,
+		nameRules:          nameRules,
+		authDisabledPolicy: authDisabledPolicy,
+		authDisabledGroup:  authDisabledGroup,
 		httpClient: &http.Client{
-			Timeout: time.Second * 30,
+			Timeout: lookupTimeout,
 		},
-	}, err
+		lookupTimeout: lookupTimeout,
+		cache:         newTokenCache(opts.CacheSize, opts.CacheTTL, opts.CacheNegativeTTL),
+		signatureKey:  signatureKey,
+	}, nil
+}
+
+func newFromConfig(data []byte) (authentication.Provider, error) {
+	cfg := config{
+		CacheSize:        defaultCacheSize,
+		CacheTTL:         defaultCacheTTL.String(),
+		CacheNegativeTTL: defaultCacheNegativeTTL.String(),
+	}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, err
+		}
+	}
+
+	opts := Options{
+		Environment:        cfg.Environment,
+		AdminGroup:         cfg.AdminGroup,
+		RoleGroups:         cfg.RoleGroups,
+		Names:              cfg.Names,
+		AuthDisabledPolicy: cfg.AuthDisabledPolicy,
+		AuthDisabledGroup:  cfg.AuthDisabledGroup,
+		CacheSize:          cfg.CacheSize,
+	}
+	var err error
+	if opts.CacheTTL, err = time.ParseDuration(cfg.CacheTTL); err != nil {
+		return nil, fmt.Errorf("Invalid cacheTTL: %v", err)
+	}
+	if opts.CacheNegativeTTL, err = time.ParseDuration(cfg.CacheNegativeTTL); err != nil {
+		return nil, fmt.Errorf("Invalid cacheNegativeTTL: %v", err)
+	}
+	if cfg.LookupTimeout != "" {
+		if opts.LookupTimeout, err = time.ParseDuration(cfg.LookupTimeout); err != nil {
+			return nil, fmt.Errorf("Invalid lookupTimeout: %v", err)
+		}
+	}
+
+	return NewProvider(opts)
 }
 
 func (p *Provider) Lookup(token string) (*k8sAuthentication.UserInfo, error) {
+	return p.LookupContext(context.Background(), token)
+}
+
+// LookupContext gives up waiting for the lookup when the context is done.
+// Concurrent lookups of the same token share a single evaluation against
+// Rancher, which is bounded by the lookup timeout rather than the context of
+// any one of them.
+func (p *Provider) LookupContext(ctx context.Context, token string) (*k8sAuthentication.UserInfo, error) {
 	if token == "" {
 		return nil, nil
 	}
 
 	log.Debugf("Raw token: %s", token)
 
-	if token == p.bootstrapToken {
-		log.Debug("Raw token is the same as bootstrap token")
-		return &k8sAuthentication.UserInfo{
-			Username: bootstrapUser,
-			Groups:   []string{kubernetesMasterGroup},
-		}, nil
+	key := fingerprint(token)
+	if value, ok := p.cache.get(key); ok {
+		log.Debugf("Cache hit for token %s", key)
+		if err, ok := value.(error); ok {
+			return nil, err
+		}
+		userInfo, _ := value.(*k8sAuthentication.UserInfo)
+		return copyUserInfo(userInfo), nil
 	}
-
-	if p.authDisabled() {
-		log.Debug("Detected that auth is disabled")
-		return &k8sAuthentication.UserInfo{
-			Username: adminUser,
-			Groups:   []string{kubernetesMasterGroup},
-		}, nil
+	if p.cache != nil {
+		log.Debugf("Cache miss for token %s", key)
 	}
 
-	decodedTokenBytes, err := base64.StdEncoding.DecodeString(token)
+	userInfo, shared, err := p.inflight.do(ctx, key, func() (*k8sAuthentication.UserInfo, error) {
+		lookupCtx, cancel := context.WithTimeout(context.Background(), p.lookupTimeout)
+		defer cancel()
+
+		generation := p.cache.currentGeneration()
+		userInfo, tags, err := p.lookup(lookupCtx, token)
+		if authentication.IsInvalidToken(err) {
+			p.cache.add(key, err, tags, generation)
+		}
+		if err != nil {
+			return nil, err
+		}
+		p.cache.add(key, userInfo, tags, generation)
+		return userInfo, nil
+	})
+	if shared {
+		log.Debugf("Shared in-flight lookup for token %s", key)
+	}
 	if err != nil {
 		return nil, err
 	}
-	token = string(decodedTokenBytes)
 
-	log.Debugf("Decoded token: %s", token)
+	return copyUserInfo(userInfo), nil
+}
+
+// EncodeToken encodes a Rancher API key as the token Lookup expects, the
+// base64 encoded Authorization header for the key.
+func EncodeToken(accessKey, secretKey string) string {
+	authorization := "Basic " + base64.StdEncoding.EncodeToString([]byte(accessKey+":"+secretKey))
+	return base64.StdEncoding.EncodeToString([]byte(authorization))
+}
 
-	req, err := http.NewRequest("GET", p.url+"/identity", nil)
+// decodeToken returns the Authorization header a token encodes, rejecting
+// tokens that are not a base64 encoded scheme followed by credentials.
+func decodeToken(token string) (string, error) {
+	decoded, err := base64.StdEncoding.DecodeString(token)
 	if err != nil {
-		return nil, err
+		return "", authentication.NewInvalidTokenError("token is not base64 encoded")
 	}
+	authorization := string(decoded)
 
-	req.Header.Add("Authorization", token)
+	parts := strings.SplitN(authorization, " ", 2)
+	if len(parts) != 2 || parts[0] == "" || strings.TrimSpace(parts[1]) == "" {
+		return "", authentication.NewInvalidTokenError("token does not encode an Authorization header")
+	}
+	for _, c := range authorization {
+		if c < ' ' || c > '~' {
+			return "", authentication.NewInvalidTokenError("token encodes an Authorization header with invalid characters")
+		}
+	}
+	return authorization, nil
+}
 
-	resp, err := p.httpClient.Do(req)
+func (p *Provider) lookup(ctx context.Context, token string) (*k8sAuthentication.UserInfo, []string, error) {
+	token, err := decodeToken(token)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
 	}
-	defer resp.Body.Close()
 
-	data, err := ioutil.ReadAll(resp.Body)
+	log.Debugf("Decoded token: %s", token)
+
+	authDisabled, err := p.authDisabled(ctx)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if authDisabled {
+		return p.authDisabledUser()
 	}
 
 	var identityCollection client.IdentityCollection
-	if err = json.Unmarshal(data, &identityCollection); err != nil {
-		return nil, err
+	if err := p.get(ctx, "/identity", token, &identityCollection); err != nil {
+		return nil, apiKeyTags(token), err
 	}
 
-	userInfo := getUserInfoFromIdentityCollection(&identityCollection)
+	tags := append(identityTags(identityCollection), apiKeyTags(token)...)
 
-	isAdmin, err := p.isAdmin(token)
+	userInfo, ok := getUserInfoFromIdentityCollection(&identityCollection, p.nameRules)
+	if !ok {
+		log.Debug("Not authenticated, no usable username")
+		return nil, tags, nil
+	}
+	if identityIDs := userInfo.Extra[IdentityIDsExtra]; len(identityIDs) > 0 {
+		userInfo.Extra[IdentitySignatureExtra] = k8sAuthentication.ExtraValue{p.signIdentityIDs(identityIDs)}
+	}
+
+	account, err := p.callerAccount(ctx, token, identityCollection)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	if account == nil {
+		log.Debug("Not authenticated, no account found for the identity")
+		return nil, tags, nil
+	}
+	tags = append(tags, accountTag(account.Id))
+	if !isActive(account.State) {
+		log.Infof("Not authenticating %s, account %s is %s", userInfo.Username, account.Id, account.State)
+		return nil, tags, nil
+	}
+	denial, err := p.credentialDenial(ctx, token, account)
+	if err != nil {
+		return nil, nil, err
+	}
+	if denial != "" {
+		log.Infof("Not authenticating %s, %s", userInfo.Username, denial)
+		return nil, tags, nil
 	}
 
-	if isAdmin {
-		log.Debug("Authenticated as master (admin)")
-		userInfo.Groups = append(userInfo.Groups, kubernetesMasterGroup)
+	if account.Kind == adminKind {
+		log.Debug("Authenticated as admin")
+		userInfo.Groups = append(userInfo.Groups, p.adminGroup)
 	} else {
-		environmentIdentities, err := getEnvironmentIdentities(p.client)
+		role, err := p.EnvironmentRole(ctx, getIdentityIDs(identityCollection))
 		if err != nil {
-			return nil, err
+			return nil, nil, err
 		}
-
-		authenticated, master := shouldBeAuthenticated(identityCollection, environmentIdentities)
-		if !authenticated {
+		if role == "" {
 			log.Debug("Not authenticated")
-			return nil, nil
+			return nil, tags, nil
 		}
 
-		if master {
-			log.Debug("Authenticated as master (owner)")
-			userInfo.Groups = append(userInfo.Groups, kubernetesMasterGroup)
-		} else {
-			log.Debug("Not authenticated as master")
-		}
+		log.Debugf("Authenticated as %s", role)
+		userInfo.Groups = append(userInfo.Groups, p.roleGroups[role]...)
 	}
 
-	return &userInfo, nil
+	return &userInfo, tags, nil
 }
 
-func (p *Provider) authDisabled() bool {
-	req, err := http.NewRequest("GET", p.url+"/settings/api.security.enabled", nil)
-	if err != nil {
-		return false
+// EnvironmentRole returns the most privileged role held in the environment
+// by any of the given identities, or an empty string if none are members.
+// Roles are cached alongside tokens and purged when the membership of any of
+// the identities changes.
+func (p *Provider) EnvironmentRole(ctx context.Context, identityIDs []string) (string, error) {
+	key := roleCacheKey(identityIDs)
+	if value, ok := p.cache.get(key); ok {
+		role, _ := value.(string)
+		return role, nil
 	}
 
-	resp, err := p.httpClient.Do(req)
+	generation := p.cache.currentGeneration()
+	environmentIdentities, err := getEnvironmentIdentities(ctx, p.client, p.environmentID, identityIDs)
 	if err != nil {
-		return false
+		if ctxErr := authentication.ContextError(ctx); ctxErr != nil {
+			return "", ctxErr
+		}
+		return "", authentication.NewUnavailableError(backendName, err)
 	}
-	defer resp.Body.Close()
+	role := environmentRole(identityIDs, environmentIdentities)
 
-	data, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return false
+	var tags []string
+	for _, id := range identityIDs {
+		tags = append(tags, identityTag(id))
+	}
+	p.cache.add(key, role, tags, generation)
+	return role, nil
+}
+
+// copyUserInfo deep copies a cached user so that callers modifying its
+// groups or extras cannot change the cached entry.
+func copyUserInfo(userInfo *k8sAuthentication.UserInfo) *k8sAuthentication.UserInfo {
+	if userInfo == nil {
+		return nil
+	}
+	userInfoCopy := *userInfo
+	if userInfo.Groups != nil {
+		userInfoCopy.Groups = append([]string{}, userInfo.Groups...)
 	}
+	if userInfo.Extra != nil {
+		userInfoCopy.Extra = make(map[string]k8sAuthentication.ExtraValue, len(userInfo.Extra))
+		for key, value := range userInfo.Extra {
+			userInfoCopy.Extra[key] = append(k8sAuthentication.ExtraValue{}, value...)
+		}
+	}
+	return &userInfoCopy
+}
 
+// authDisabled reports whether Rancher has access control disabled. While
+// the setting cannot be read no token is accepted, and the failure is
+// reported by Healthy until the setting is read again.
+func (p *Provider) authDisabled(ctx context.Context) (bool, error) {
 	var setting client.Setting
-	if err = json.Unmarshal(data, &setting); err != nil {
-		return false
+	if err := p.get(ctx, "/settings/"+apiSecurityEnabledSetting, "", &setting); err != nil {
+		log.Errorf("Failed to read Rancher setting %s, rejecting tokens: %v", apiSecurityEnabledSetting, err)
+		if ctx.Err() == nil {
+			p.health.set(err)
+		}
+		return false, err
 	}
+	p.health.set(nil)
+
+	return setting.Value == "false", nil
+}
+
+func (p *Provider) authDisabledUser() (*k8sAuthentication.UserInfo, []string, error) {
+	tags := []string{settingTag(apiSecurityEnabledSetting)}
+	switch p.authDisabledPolicy {
+	case AuthDisabledAdmin:
+		log.Debug("Access control is disabled, authenticating as admin")
+		return &k8sAuthentication.UserInfo{
+			Username: adminUser,
+			Groups:   []string{p.adminGroup},
+		}, tags, nil
+	case AuthDisabledReadOnly:
+		log.Debug("Access control is disabled, authenticating as anonymous")
+		return &k8sAuthentication.UserInfo{
+			Username: anonymousUser,
+			Groups:   []string{p.authDisabledGroup},
+		}, tags, nil
+	}
+	log.Warn("Access control is disabled in Rancher, rejecting token")
+	return nil, tags, nil
+}
+
+// AdminGroup returns the group given to Rancher admins.
+func (p *Provider) AdminGroup() string {
+	return p.adminGroup
+}
 
-	return setting.Value == "false"
+// Healthy returns the last failure to read whether access control is
+// enabled in Rancher, or nil. While unhealthy the setting is read again on
+// every call, so that the provider recovers without waiting for a lookup.
+func (p *Provider) Healthy() error {
+	if p.health.get() == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), healthProbeTimeout)
+	defer cancel()
+	p.authDisabled(ctx)
+	return p.health.get()
 }
 
-func (p *Provider) isAdmin(token string) (bool, error) {
-	req, err := http.NewRequest("GET", p.url+"/accounts", nil)
+// get fetches a Rancher API resource, optionally on behalf of the given
+// Authorization header. Rancher refusing the Authorization header is reported
+// as an invalid token. Any other failure to obtain a usable response means
+// the token could not be checked and is reported as the backend being
+// unavailable, wrapping a *client.ApiError for error responses.
+func (p *Provider) get(ctx context.Context, path, authorization string, respObject interface{}) error {
+	req, err := http.NewRequest("GET", p.url+path, nil)
 	if err != nil {
-		return false, err
+		return err
 	}
+	req = req.WithContext(ctx)
 
-	req.Header.Add("Authorization", token)
+	if authorization != "" {
+		req.Header.Add("Authorization", authorization)
+	}
 
 	resp, err := p.httpClient.Do(req)
 	if err != nil {
-		return false, err
+		return authentication.NewUnavailableError(backendName, err)
 	}
 	defer resp.Body.Close()
 
 	data, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		return false, err
+		return authentication.NewUnavailableError(backendName, err)
 	}
 
-	var accountCollection client.AccountCollection
-	if err = json.Unmarshal(data, &accountCollection); err != nil {
-		return false, err
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		apiErr := newAPIError(resp, path, data)
+		if (resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden) && authorization != "" {
+			log.Infof("Rancher rejected credentials: %v", apiErr)
+			return authentication.NewInvalidTokenError("rejected by Rancher with %s", resp.Status)
+		}
+		if resp.StatusCode == http.StatusNotFound {
+			log.Debugf("Rancher resource not found: %v", apiErr)
+		} else {
+			log.Warnf("Rancher request failed: %v", apiErr)
+		}
+		return authentication.NewUnavailableError(backendName, apiErr)
 	}
 
-	for _, account := range accountCollection.Data {
-		if account.Kind == "admin" {
-			return true, nil
+	if err = json.Unmarshal(data, respObject); err != nil {
+		return authentication.NewUnavailableError(backendName, fmt.Errorf("Failed to parse response from %s: %v", path, err))
+	}
+
+	return nil
+}
+
+// newAPIError describes a failed Rancher response in the same shape as the
+// go-rancher client, summarising a Rancher error body when there is one.
+func newAPIError(resp *http.Response, path string, data []byte) *client.ApiError {
+	body := string(data)
+	if len(body) > maxErrorBody {
+		body = body[:maxErrorBody] + "..."
+	}
+
+	var rancherErr struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+		Detail  string `json:"detail"`
+	}
+	if json.Unmarshal(data, &rancherErr) == nil && rancherErr.Code != "" {
+		body = fmt.Sprintf("code=%s, message=%s", rancherErr.Code, rancherErr.Message)
+		if rancherErr.Detail != "" {
+			body += ", detail=" + rancherErr.Detail
 		}
 	}
 
-	return false, nil
+	return &client.ApiError{
+		StatusCode: resp.StatusCode,
+		Url:        path,
+		Status:     resp.Status,
+		Body:       body,
+		Msg:        fmt.Sprintf("Bad response statusCode [%d]. Status [%s]. Body: [%s] from [%s]", resp.StatusCode, resp.Status, body, path),
+	}
 }
@@ -0,0 +1,131 @@
+package rancherauthentication
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rancher/kubernetes-auth/authentication"
+)
+
+func TestDecodeToken(t *testing.T) {
+	encode := func(s string) string {
+		return base64.StdEncoding.EncodeToString([]byte(s))
+	}
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+	}{
+		{"basic", EncodeToken("access", "secret"), "Basic " + encode("access:secret")},
+		{"bearer", encode("Bearer jwt"), "Bearer jwt"},
+		{"not base64", "Basic !", ""},
+		{"no scheme", encode("access:secret"), ""},
+		{"no credentials", encode("Basic "), ""},
+		{"header injection", encode("Basic a\r\nX-Admin: true"), ""},
+		{"empty", "", ""},
+	}
+
+	for _, test := range tests {
+		authorization, err := decodeToken(test.token)
+		if test.authorization == "" {
+			if !authentication.IsInvalidToken(err) {
+				t.Errorf("%s: got %q, %v, expected an invalid token", test.name, authorization, err)
+			}
+			continue
+		}
+		if err != nil || authorization != test.authorization {
+			t.Errorf("%s: got %q, %v, expected %q", test.name, authorization, err, test.authorization)
+		}
+	}
+}
+
+func TestLookupDecodesBeforeRancher(t *testing.T) {
+	var requests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	p := &Provider{url: server.URL, httpClient: http.DefaultClient, lookupTimeout: time.Minute}
+	if _, err := p.Lookup("not a token"); !authentication.IsInvalidToken(err) {
+		t.Errorf("error %v, expected an invalid token", err)
+	}
+	if requests != 0 {
+		t.Errorf("made %d Rancher requests for an undecodable token", requests)
+	}
+}
+
+func TestHealthyRecovers(t *testing.T) {
+	var failing int32 = 1
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/settings/"+apiSecurityEnabledSetting {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		if atomic.LoadInt32(&failing) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Write([]byte(`{"name":"api.security.enabled","value":"true"}`))
+	}))
+	defer server.Close()
+
+	p := &Provider{url: server.URL, httpClient: http.DefaultClient, lookupTimeout: time.Minute}
+	p.health.set(errors.New("earlier failure"))
+
+	if err := p.Healthy(); err == nil {
+		t.Error("healthy while Rancher is failing")
+	}
+	atomic.StoreInt32(&failing, 0)
+	if err := p.Healthy(); err != nil {
+		t.Errorf("still unhealthy after Rancher recovered: %v", err)
+	}
+}
+
+func TestLookupCachesRejections(t *testing.T) {
+	var identityRequests int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/settings/" + apiSecurityEnabledSetting:
+			w.Write([]byte(`{"name":"api.security.enabled","value":"true"}`))
+		case "/identity":
+			atomic.AddInt32(&identityRequests, 1)
+			w.WriteHeader(http.StatusUnauthorized)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer server.Close()
+
+	p := &Provider{
+		url:           server.URL,
+		httpClient:    http.DefaultClient,
+		lookupTimeout: time.Minute,
+		cache:         newTokenCache(10, time.Minute, 10*time.Second),
+	}
+	token := EncodeToken("access", "revoked")
+	start := time.Now()
+
+	for i, age := range []time.Duration{0, 5 * time.Second, 11 * time.Second} {
+		restore := setNow(start.Add(age))
+		userInfo, err := p.Lookup(token)
+		restore()
+		if userInfo != nil || !authentication.IsInvalidToken(err) {
+			t.Errorf("lookup %d: got %+v, %v, expected an invalid token", i, userInfo, err)
+		}
+	}
+	if identityRequests != 2 {
+		t.Errorf("made %d identity requests, expected 2 with the rejection cached in between", identityRequests)
+	}
+
+	if purged := p.cache.purge("apikey:access"); purged != 1 {
+		t.Errorf("purged %d entries for the rejected API key, expected 1", purged)
+	}
+}
@@ -0,0 +1,227 @@
+package rancherauthentication
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
+)
+
+// now is replaced in tests
+var now = time.Now
+
+type cacheEntry struct {
+	key     string
+	value   interface{}
+	expires time.Time
+	tags    []string
+}
+
+// tokenCache is a size bounded LRU of authentication decisions, the user a
+// token authenticates as or the role of a set of identities. A nil user, an
+// error rejecting the token or an empty role records a negative decision.
+// Entries are keyed by the token fingerprint so raw tokens are never held in
+// memory longer than a request. Each entry is also indexed by tags naming
+// the Rancher resources the decision was derived from so that it can be
+// purged when they change.
+// Purges record the generation at which each tag was purged, and decisions
+// from lookups started before a purge of one of their tags are not added as
+// they may predate the change.
+type tokenCache struct {
+	sync.Mutex
+	size        int
+	ttl         time.Duration
+	negativeTTL time.Duration
+	entries     map[string]*list.Element
+	tags        map[string]map[string]bool
+	lru         *list.List
+	generation  uint64
+	purged      map[string]uint64
+	purgedFloor uint64
+}
+
+func newTokenCache(size int, ttl, negativeTTL time.Duration) *tokenCache {
+	if size <= 0 || (ttl <= 0 && negativeTTL <= 0) {
+		return nil
+	}
+	return &tokenCache{
+		size:        size,
+		ttl:         ttl,
+		negativeTTL: negativeTTL,
+		entries:     map[string]*list.Element{},
+		tags:        map[string]map[string]bool{},
+		lru:         list.New(),
+		purged:      map[string]uint64{},
+	}
+}
+
+func fingerprint(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *tokenCache) get(key string) (interface{}, bool) {
+	if c == nil {
+		return nil, false
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	element, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if now().After(entry.expires) {
+		c.remove(element)
+		return nil, false
+	}
+
+	c.lru.MoveToFront(element)
+	return entry.value, true
+}
+
+// currentGeneration returns the generation to pass to add for a lookup
+// starting now.
+func (c *tokenCache) currentGeneration() uint64 {
+	if c == nil {
+		return 0
+	}
+
+	c.Lock()
+	defer c.Unlock()
+	return c.generation
+}
+
+func (c *tokenCache) add(key string, value interface{}, tags []string, generation uint64) {
+	if c == nil {
+		return
+	}
+
+	ttl := c.ttl
+	if isNegative(value) {
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	if c.stale(tags, generation) {
+		return
+	}
+
+	if element, ok := c.entries[key]; ok {
+		c.remove(element)
+	}
+
+	c.entries[key] = c.lru.PushFront(&cacheEntry{
+		key:     key,
+		value:   value,
+		expires: now().Add(ttl),
+		tags:    tags,
+	})
+	for _, tag := range tags {
+		if c.tags[tag] == nil {
+			c.tags[tag] = map[string]bool{}
+		}
+		c.tags[tag][key] = true
+	}
+
+	for c.lru.Len() > c.size {
+		c.remove(c.lru.Back())
+	}
+}
+
+// stale reports whether any of the tags was purged after the given
+// generation.
+func (c *tokenCache) stale(tags []string, generation uint64) bool {
+	if generation < c.purgedFloor {
+		return true
+	}
+	for _, tag := range tags {
+		if generation < c.purged[tag] {
+			return true
+		}
+	}
+	return false
+}
+
+func isNegative(value interface{}) bool {
+	switch v := value.(type) {
+	case *k8sAuthentication.UserInfo:
+		return v == nil
+	case string:
+		return v == ""
+	case error:
+		return true
+	}
+	return value == nil
+}
+
+// purge removes every entry tagged with any of the given tags and returns
+// the number of entries removed.
+func (c *tokenCache) purge(tags ...string) int {
+	if c == nil {
+		return 0
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.generation++
+	if len(c.purged)+len(tags) > c.size {
+		// Bound the purge record by treating every tag as purged now
+		c.purgedFloor = c.generation
+		c.purged = map[string]uint64{}
+	}
+
+	purged := 0
+	for _, tag := range tags {
+		c.purged[tag] = c.generation
+		for key := range c.tags[tag] {
+			if element, ok := c.entries[key]; ok {
+				c.remove(element)
+				purged++
+			}
+		}
+	}
+	return purged
+}
+
+func (c *tokenCache) purgeAll() int {
+	if c == nil {
+		return 0
+	}
+
+	c.Lock()
+	defer c.Unlock()
+
+	c.generation++
+	c.purgedFloor = c.generation
+	c.purged = map[string]uint64{}
+	purged := c.lru.Len()
+	c.entries = map[string]*list.Element{}
+	c.tags = map[string]map[string]bool{}
+	c.lru.Init()
+	return purged
+}
+
+func (c *tokenCache) remove(element *list.Element) {
+	entry := element.Value.(*cacheEntry)
+	c.lru.Remove(element)
+	delete(c.entries, entry.key)
+	for _, tag := range entry.tags {
+		delete(c.tags[tag], entry.key)
+		if len(c.tags[tag]) == 0 {
+			delete(c.tags, tag)
+		}
+	}
+}
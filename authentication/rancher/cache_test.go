@@ -0,0 +1,243 @@
+package rancherauthentication
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
+)
+
+func setNow(t time.Time) func() {
+	previous := now
+	now = func() time.Time { return t }
+	return func() { now = previous }
+}
+
+func TestTokenCacheExpiry(t *testing.T) {
+	start := time.Now()
+	user := &k8sAuthentication.UserInfo{Username: "user"}
+
+	tests := []struct {
+		name     string
+		userInfo *k8sAuthentication.UserInfo
+		age      time.Duration
+		found    bool
+	}{
+		{"positive fresh", user, 59 * time.Second, true},
+		{"positive expired", user, 61 * time.Second, false},
+		{"negative fresh", nil, 9 * time.Second, true},
+		{"negative expired", nil, 11 * time.Second, false},
+	}
+
+	for _, test := range tests {
+		cache := newTokenCache(10, time.Minute, 10*time.Second)
+
+		restore := setNow(start)
+		cache.add("key", test.userInfo, nil, 0)
+		restore()
+
+		restore = setNow(start.Add(test.age))
+		userInfo, found := cache.get("key")
+		restore()
+
+		if found != test.found {
+			t.Errorf("%s: found %v, expected %v", test.name, found, test.found)
+		}
+		if found && userInfo != test.userInfo {
+			t.Errorf("%s: got %v, expected %v", test.name, userInfo, test.userInfo)
+		}
+	}
+}
+
+func TestTokenCacheDisabled(t *testing.T) {
+	tests := []struct {
+		name        string
+		size        int
+		ttl         time.Duration
+		negativeTTL time.Duration
+	}{
+		{"no size", 0, time.Minute, time.Minute},
+		{"no ttls", 10, 0, 0},
+	}
+
+	for _, test := range tests {
+		cache := newTokenCache(test.size, test.ttl, test.negativeTTL)
+		if cache != nil {
+			t.Errorf("%s: expected caching to be disabled", test.name)
+		}
+		cache.add("key", &k8sAuthentication.UserInfo{}, nil, 0)
+		if _, found := cache.get("key"); found {
+			t.Errorf("%s: disabled cache returned an entry", test.name)
+		}
+		if cache.purge("tag") != 0 || cache.purgeAll() != 0 {
+			t.Errorf("%s: disabled cache purged entries", test.name)
+		}
+	}
+}
+
+func TestTokenCacheNegativeTTLDisabled(t *testing.T) {
+	cache := newTokenCache(10, time.Minute, 0)
+	cache.add("key", nil, nil, 0)
+	if _, found := cache.get("key"); found {
+		t.Error("negative decision cached without a negative TTL")
+	}
+}
+
+func TestTokenCacheEviction(t *testing.T) {
+	cache := newTokenCache(2, time.Minute, time.Minute)
+	cache.add("a", nil, nil, 0)
+	cache.add("b", nil, nil, 0)
+	cache.get("a")
+	cache.add("c", nil, nil, 0)
+
+	for key, expected := range map[string]bool{"a": true, "b": false, "c": true} {
+		if _, found := cache.get(key); found != expected {
+			t.Errorf("%s: found %v, expected %v", key, found, expected)
+		}
+	}
+}
+
+func TestTokenCachePurge(t *testing.T) {
+	tests := []struct {
+		name      string
+		tags      []string
+		purged    int
+		remaining []string
+	}{
+		{"single tag", []string{"account:1a1"}, 2, []string{"c"}},
+		{"shared tag", []string{"identity:ldap_user:x"}, 1, []string{"b", "c"}},
+		{"several tags", []string{"identity:ldap_user:x", "apikey:key"}, 2, []string{"b"}},
+		{"unknown tag", []string{"account:1a9"}, 0, []string{"a", "b", "c"}},
+	}
+
+	for _, test := range tests {
+		cache := newTokenCache(10, time.Minute, time.Minute)
+		cache.add("a", nil, []string{"account:1a1", "identity:ldap_user:x"}, 0)
+		cache.add("b", nil, []string{"account:1a1"}, 0)
+		cache.add("c", nil, []string{"account:1a2", "apikey:key"}, 0)
+
+		if purged := cache.purge(test.tags...); purged != test.purged {
+			t.Errorf("%s: purged %d, expected %d", test.name, purged, test.purged)
+		}
+		var remaining []string
+		for _, key := range []string{"a", "b", "c"} {
+			if _, found := cache.get(key); found {
+				remaining = append(remaining, key)
+			}
+		}
+		if !reflect.DeepEqual(remaining, test.remaining) {
+			t.Errorf("%s: remaining %v, expected %v", test.name, remaining, test.remaining)
+		}
+	}
+}
+
+func TestTokenCachePurgeAll(t *testing.T) {
+	cache := newTokenCache(10, time.Minute, time.Minute)
+	cache.add("a", nil, []string{"tag"}, 0)
+	cache.add("b", nil, nil, 0)
+	if purged := cache.purgeAll(); purged != 2 {
+		t.Errorf("purged %d, expected 2", purged)
+	}
+	if _, found := cache.get("a"); found {
+		t.Error("entry survived purgeAll")
+	}
+	if purged := cache.purge("tag"); purged != 0 {
+		t.Errorf("tag index survived purgeAll, purged %d", purged)
+	}
+}
+
+func TestCopyUserInfo(t *testing.T) {
+	cached := &k8sAuthentication.UserInfo{
+		Username: "user",
+		Groups:   []string{"a", "b"},
+		Extra: map[string]k8sAuthentication.ExtraValue{
+			"key": {"value"},
+		},
+	}
+
+	userInfo := copyUserInfo(cached)
+	userInfo.Groups[0] = "changed"
+	userInfo.Groups = append(userInfo.Groups, "added")
+	userInfo.Extra["key"][0] = "changed"
+	userInfo.Extra["other"] = k8sAuthentication.ExtraValue{"added"}
+
+	expected := &k8sAuthentication.UserInfo{
+		Username: "user",
+		Groups:   []string{"a", "b"},
+		Extra: map[string]k8sAuthentication.ExtraValue{
+			"key": {"value"},
+		},
+	}
+	if !reflect.DeepEqual(cached, expected) {
+		t.Errorf("cached user changed to %+v", cached)
+	}
+	if copyUserInfo(nil) != nil {
+		t.Error("copy of nil user is not nil")
+	}
+}
+
+func TestTokenCacheStaleAdd(t *testing.T) {
+	tests := []struct {
+		name  string
+		purge func(c *tokenCache)
+		found bool
+	}{
+		{"no purge", func(c *tokenCache) {}, true},
+		{"tag purge", func(c *tokenCache) { c.purge("account:1a1") }, false},
+		{"unrelated tag purge", func(c *tokenCache) { c.purge("account:1a9") }, true},
+		{"one of several tags purged", func(c *tokenCache) { c.purge("apikey:x", "account:1a1") }, false},
+		{"purge record full", func(c *tokenCache) {
+			for i := 0; i <= 10; i++ {
+				c.purge(fmt.Sprintf("account:1a%d", i+10))
+			}
+		}, false},
+		{"purge all", func(c *tokenCache) { c.purgeAll() }, false},
+	}
+
+	for _, test := range tests {
+		cache := newTokenCache(10, time.Minute, time.Minute)
+		generation := cache.currentGeneration()
+		test.purge(cache)
+		cache.add("key", nil, []string{"account:1a1"}, generation)
+		if _, found := cache.get("key"); found != test.found {
+			t.Errorf("%s: found %v, expected %v", test.name, found, test.found)
+		}
+	}
+}
+
+func TestTokenCacheRoles(t *testing.T) {
+	start := time.Now()
+	tests := []struct {
+		name  string
+		role  string
+		age   time.Duration
+		found bool
+	}{
+		{"member fresh", "member", 59 * time.Second, true},
+		{"member expired", "member", 61 * time.Second, false},
+		{"not a member fresh", "", 9 * time.Second, true},
+		{"not a member expired", "", 11 * time.Second, false},
+	}
+
+	for _, test := range tests {
+		cache := newTokenCache(10, time.Minute, 10*time.Second)
+		key := roleCacheKey([]string{"ldap_user:b", "ldap_group:a"})
+
+		restore := setNow(start)
+		cache.add(key, test.role, nil, 0)
+		restore()
+
+		restore = setNow(start.Add(test.age))
+		value, found := cache.get(roleCacheKey([]string{"ldap_group:a", "ldap_user:b"}))
+		restore()
+
+		if found != test.found {
+			t.Errorf("%s: found %v, expected %v", test.name, found, test.found)
+		}
+		if role, _ := value.(string); found && role != test.role {
+			t.Errorf("%s: got role %s, expected %s", test.name, role, test.role)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package rancherauthentication
+
+import (
+	"context"
+	"sync"
+
+	"github.com/rancher/kubernetes-auth/authentication"
+	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
+)
+
+type lookupCall struct {
+	done     chan struct{}
+	userInfo *k8sAuthentication.UserInfo
+	err      error
+}
+
+// lookupGroup coalesces concurrent lookups of the same token so that a burst
+// of reviews shares a single evaluation against Rancher. Lookups of
+// different tokens never wait on each other. The evaluation does not belong
+// to any one caller, each caller stops waiting for it when its own context
+// is done.
+type lookupGroup struct {
+	sync.Mutex
+	calls map[string]*lookupCall
+}
+
+func (g *lookupGroup) do(ctx context.Context, key string, fn func() (*k8sAuthentication.UserInfo, error)) (*k8sAuthentication.UserInfo, bool, error) {
+	g.Lock()
+	if g.calls == nil {
+		g.calls = map[string]*lookupCall{}
+	}
+	call, shared := g.calls[key]
+	if !shared {
+		call = &lookupCall{
+			done: make(chan struct{}),
+		}
+		g.calls[key] = call
+		go func() {
+			call.userInfo, call.err = fn()
+			g.Lock()
+			delete(g.calls, key)
+			g.Unlock()
+			close(call.done)
+		}()
+	}
+	g.Unlock()
+
+	select {
+	case <-call.done:
+		return call.userInfo, shared, call.err
+	case <-ctx.Done():
+		return nil, shared, authentication.ContextError(ctx)
+	}
+}
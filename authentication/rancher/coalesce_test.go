@@ -0,0 +1,78 @@
+package rancherauthentication
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/rancher/kubernetes-auth/authentication"
+	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
+)
+
+func TestLookupGroupShares(t *testing.T) {
+	var g lookupGroup
+	var calls int32
+	release := make(chan struct{})
+	fn := func() (*k8sAuthentication.UserInfo, error) {
+		atomic.AddInt32(&calls, 1)
+		<-release
+		return &k8sAuthentication.UserInfo{Username: "user"}, nil
+	}
+
+	results := make(chan bool, 3)
+	for i := 0; i < 3; i++ {
+		go func() {
+			userInfo, _, err := g.do(context.Background(), "key", fn)
+			results <- err == nil && userInfo.Username == "user"
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+
+	for i := 0; i < 3; i++ {
+		if !<-results {
+			t.Error("lookup did not get the shared result")
+		}
+	}
+	if calls != 1 {
+		t.Errorf("evaluated %d times, expected once", calls)
+	}
+}
+
+func TestLookupGroupDetached(t *testing.T) {
+	var g lookupGroup
+	release := make(chan struct{})
+	fn := func() (*k8sAuthentication.UserInfo, error) {
+		<-release
+		return &k8sAuthentication.UserInfo{Username: "user"}, nil
+	}
+
+	first, cancel := context.WithCancel(context.Background())
+	firstDone := make(chan error, 1)
+	go func() {
+		_, _, err := g.do(first, "key", fn)
+		firstDone <- err
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	secondDone := make(chan *k8sAuthentication.UserInfo, 1)
+	go func() {
+		userInfo, shared, err := g.do(context.Background(), "key", fn)
+		if err != nil || !shared {
+			t.Errorf("second lookup got shared %v, error %v", shared, err)
+		}
+		secondDone <- userInfo
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	cancel()
+	if err := <-firstDone; !authentication.IsUnavailable(err) {
+		t.Errorf("cancelled lookup got %v, expected unavailable", err)
+	}
+
+	close(release)
+	if userInfo := <-secondDone; userInfo == nil || userInfo.Username != "user" {
+		t.Errorf("second lookup got %+v after the first gave up", userInfo)
+	}
+}
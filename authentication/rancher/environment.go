@@ -0,0 +1,124 @@
+package rancherauthentication
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/rancher/go-rancher/v2"
+)
+
+const (
+	// MetadataEnvironment selects the environment the service runs in, as
+	// reported by rancher-metadata
+	MetadataEnvironment = "metadata"
+
+	metadataAddressEnv     = "RANCHER_METADATA_ADDRESS"
+	defaultMetadataAddress = "169.254.169.250"
+	metadataUUIDPath       = "/2015-12-19/self/stack/environment_uuid"
+)
+
+// resolveEnvironment returns the ID of the environment given by ID, UUID or
+// name, or by MetadataEnvironment. With no environment given, the API key
+// must be able to see exactly one.
+func resolveEnvironment(rancherClient *client.RancherClient, environment string) (string, error) {
+	if environment == MetadataEnvironment {
+		uuid, err := metadataEnvironmentUUID()
+		if err != nil {
+			return "", fmt.Errorf("Failed to discover the environment from rancher-metadata: %v", err)
+		}
+		environment = uuid
+	}
+
+	projects, err := listProjects(rancherClient)
+	if err != nil {
+		return "", fmt.Errorf("Failed to list Rancher environments: %v", err)
+	}
+
+	if environment == "" {
+		switch len(projects) {
+		case 0:
+			return "", fmt.Errorf("No Rancher environment is visible to the API key")
+		case 1:
+			return projects[0].Id, nil
+		}
+		return "", fmt.Errorf("The API key can see %d Rancher environments (%s), select one by ID, name or UUID", len(projects), projectNames(projects))
+	}
+
+	for _, project := range projects {
+		if project.Id == environment || project.Uuid == environment {
+			return project.Id, nil
+		}
+	}
+
+	var matches []client.Project
+	for _, project := range projects {
+		if project.Name == environment {
+			matches = append(matches, project)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return "", fmt.Errorf("No Rancher environment with ID, name or UUID %s is visible to the API key", environment)
+	case 1:
+		return matches[0].Id, nil
+	}
+	return "", fmt.Errorf("%d Rancher environments are named %s (%s), select one by ID or UUID", len(matches), environment, projectNames(matches))
+}
+
+func listProjects(rancherClient *client.RancherClient) ([]client.Project, error) {
+	collection, err := rancherClient.Project.List(&client.ListOpts{})
+	if err != nil {
+		return nil, err
+	}
+
+	var projects []client.Project
+	for collection != nil {
+		projects = append(projects, collection.Data...)
+		if collection, err = collection.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return projects, nil
+}
+
+func projectNames(projects []client.Project) string {
+	var names []string
+	for _, project := range projects {
+		names = append(names, fmt.Sprintf("%s %s", project.Id, project.Name))
+	}
+	return strings.Join(names, ", ")
+}
+
+func metadataEnvironmentUUID() (string, error) {
+	address := os.Getenv(metadataAddressEnv)
+	if address == "" {
+		address = defaultMetadataAddress
+	}
+
+	httpClient := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+	resp, err := httpClient.Get("http://" + address + metadataUUIDPath)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("Unexpected status %d from rancher-metadata", resp.StatusCode)
+	}
+
+	uuid := strings.TrimSpace(string(data))
+	if uuid == "" {
+		return "", fmt.Errorf("rancher-metadata returned an empty environment UUID")
+	}
+	return uuid, nil
+}
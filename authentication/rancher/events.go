@@ -0,0 +1,206 @@
+package rancherauthentication
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/gorilla/websocket"
+	"github.com/rancher/go-rancher/v2"
+)
+
+const (
+	resourceChangeEvent = "resource.change"
+
+	minEventBackoff = time.Second
+	maxEventBackoff = time.Minute
+	eventPingPeriod = 30 * time.Second
+	eventReadWait   = 3 * eventPingPeriod
+)
+
+type event struct {
+	Name         string `json:"name"`
+	ResourceType string `json:"resourceType"`
+	ResourceID   string `json:"resourceId"`
+	Data         struct {
+		Resource map[string]interface{} `json:"resource"`
+	} `json:"data"`
+}
+
+// SubscribeEvents listens to the Rancher event stream and purges cached
+// decisions derived from resources as they change. It never returns and
+// reconnects with exponential backoff whenever the subscription drops.
+func (p *Provider) SubscribeEvents() {
+	if p.cache == nil {
+		return
+	}
+
+	backoff := minEventBackoff
+	for {
+		connected, err := p.subscribe()
+		if connected {
+			backoff = minEventBackoff
+		}
+		log.Warnf("Rancher event subscription closed, reconnecting in %v: %v", backoff, err)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > maxEventBackoff {
+			backoff = maxEventBackoff
+		}
+	}
+}
+
+func (p *Provider) subscribe() (bool, error) {
+	subscribeURL, err := eventSubscriptionURL(p.url)
+	if err != nil {
+		return false, err
+	}
+
+	conn, _, err := p.client.Websocket(subscribeURL, nil)
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+
+	// Events may have been missed while disconnected
+	purged := p.cache.purgeAll()
+	log.Infof("Subscribed to Rancher events, purged %d cached decisions", purged)
+
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(eventPingPeriod)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(eventPingPeriod)); err != nil {
+					log.Debugf("Failed to ping Rancher event stream: %v", err)
+				}
+			}
+		}
+	}()
+
+	conn.SetReadDeadline(time.Now().Add(eventReadWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(eventReadWait))
+	})
+
+	for {
+		var e event
+		if err := conn.ReadJSON(&e); err != nil {
+			return true, err
+		}
+		conn.SetReadDeadline(time.Now().Add(eventReadWait))
+		p.handleEvent(e)
+	}
+}
+
+func (p *Provider) handleEvent(e event) {
+	if e.Name != resourceChangeEvent {
+		return
+	}
+
+	var tags []string
+	resource := e.Data.Resource
+	switch e.ResourceType {
+	case client.PROJECT_MEMBER_TYPE:
+		if externalID := stringField(resource, "externalId"); externalID != "" {
+			tags = append(tags, identityTag(stringField(resource, "externalIdType")+":"+externalID))
+		}
+	case client.ACCOUNT_TYPE:
+		tags = append(tags, accountTag(e.ResourceID))
+	case client.API_KEY_TYPE:
+		tags = append(tags, accountTag(stringField(resource, "accountId")))
+		if publicValue := stringField(resource, "publicValue"); publicValue != "" {
+			tags = append(tags, "apikey:"+publicValue)
+		}
+	case client.SETTING_TYPE:
+		if e.ResourceID == apiSecurityEnabledSetting || stringField(resource, "name") == apiSecurityEnabledSetting {
+			log.Infof("Setting %s changed, purged %d cached decisions", apiSecurityEnabledSetting, p.cache.purgeAll())
+		}
+		return
+	default:
+		return
+	}
+
+	if purged := p.cache.purge(tags...); purged > 0 {
+		log.Infof("%s %s changed, purged %d cached decisions", e.ResourceType, e.ResourceID, purged)
+	}
+}
+
+func eventSubscriptionURL(rancherURL string) (string, error) {
+	u, err := url.Parse(rancherURL)
+	if err != nil {
+		return "", err
+	}
+
+	switch u.Scheme {
+	case "http":
+		u.Scheme = "ws"
+	case "https":
+		u.Scheme = "wss"
+	default:
+		return "", fmt.Errorf("Unsupported Rancher URL scheme %s", u.Scheme)
+	}
+
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/subscribe"
+	u.RawQuery = url.Values{"eventNames": []string{resourceChangeEvent}}.Encode()
+	return u.String(), nil
+}
+
+func stringField(resource map[string]interface{}, field string) string {
+	value, _ := resource[field].(string)
+	return value
+}
+
+func identityTag(id string) string {
+	return "identity:" + id
+}
+
+func accountTag(id string) string {
+	return "account:" + id
+}
+
+func settingTag(name string) string {
+	return "setting:" + name
+}
+
+// identityTags names every identity, and the Rancher account behind them,
+// that an authentication decision was derived from.
+func identityTags(identityCollection client.IdentityCollection) []string {
+	var tags []string
+	for _, identity := range identityCollection.Data {
+		tags = append(tags, identityTag(identity.Id), identityTag(identity.ExternalIdType+":"+identity.ExternalId))
+		if identity.ExternalIdType == rancherIDType {
+			tags = append(tags, accountTag(identity.ExternalId))
+		}
+	}
+	return tags
+}
+
+// apiKeyTags names the API key presented in a decoded Authorization header.
+func apiKeyTags(authorization string) []string {
+	accessKey := apiKeyAccessKey(authorization)
+	if accessKey == "" {
+		return nil
+	}
+	return []string{"apikey:" + accessKey}
+}
+
+// apiKeyAccessKey returns the access key of the API key presented in a
+// decoded Authorization header, or an empty string for other credentials.
+func apiKeyAccessKey(authorization string) string {
+	if !strings.HasPrefix(authorization, "Basic ") {
+		return ""
+	}
+	credentials, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(authorization, "Basic "))
+	if err != nil {
+		return ""
+	}
+	return strings.SplitN(string(credentials), ":", 2)[0]
+}
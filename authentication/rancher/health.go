@@ -0,0 +1,21 @@
+package rancherauthentication
+
+import "sync"
+
+// healthStatus holds the last error of a recurring operation.
+type healthStatus struct {
+	sync.Mutex
+	err error
+}
+
+func (h *healthStatus) set(err error) {
+	h.Lock()
+	h.err = err
+	h.Unlock()
+}
+
+func (h *healthStatus) get() error {
+	h.Lock()
+	defer h.Unlock()
+	return h.err
+}
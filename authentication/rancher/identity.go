@@ -1,25 +1,49 @@
 package rancherauthentication
 
 import (
-	"fmt"
+	"context"
+	"sort"
+	"strings"
 
 	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/kubernetes-auth/authorization"
 	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
 )
 
-func getUserInfoFromIdentityCollection(collection *client.IdentityCollection) k8sAuthentication.UserInfo {
+const (
+	extraName           = "rancher.io/name"
+	extraExternalID     = "rancher.io/external-id"
+	extraExternalIDType = "rancher.io/external-id-type"
+	extraProfileURL     = "rancher.io/profile-url"
+	extraProjectID      = "rancher.io/project-id"
+	extraRole           = "rancher.io/role"
+
+	// IdentityIDsExtra lists every Rancher identity ID the user holds,
+	// including group identities
+	IdentityIDsExtra = "rancher.io/identity-ids"
+
+	ownerRole = "owner"
+
+	// externalIDChunkSize is the most external IDs queried in one request
+	externalIDChunkSize = 20
+)
+
+// getUserInfoFromIdentityCollection builds the user from its identities,
+// named according to the rules. It returns false if the rules leave the user
+// without a usable name.
+func getUserInfoFromIdentityCollection(collection *client.IdentityCollection, rules *nameRules) (k8sAuthentication.UserInfo, bool) {
 	var rancherIdentity client.Identity
 	var otherIdentity client.Identity
-	var groups []string
+	var groupIdentities []client.Identity
 	for _, identity := range collection.Data {
 		if identity.User {
-			if identity.ExternalIdType == "rancher_id" {
+			if identity.ExternalIdType == rancherIDType {
 				rancherIdentity = identity
 			} else {
 				otherIdentity = identity
 			}
 		} else {
-			groups = append(groups, fmt.Sprintf("%s:%s", identity.ExternalIdType, identity.Login))
+			groupIdentities = append(groupIdentities, identity)
 		}
 	}
 
@@ -28,49 +52,175 @@ func getUserInfoFromIdentityCollection(collection *client.IdentityCollection) k8
 		identity = rancherIdentity
 	}
 
+	username := rules.username(identity, rancherIdentity)
+	if username == "" {
+		return k8sAuthentication.UserInfo{}, false
+	}
+
+	extra := getExtraFromIdentity(identity)
+	if ids := getIdentityIDs(*collection); len(ids) > 0 {
+		extra[IdentityIDsExtra] = ids
+	}
+
 	return k8sAuthentication.UserInfo{
-		Username: identity.Login,
+		Username: username,
 		UID:      identity.Id,
-		Groups:   groups,
+		Groups:   rules.groups(groupIdentities),
+		Extra:    extra,
+	}, true
+}
+
+func getExtraFromIdentity(identity client.Identity) map[string]k8sAuthentication.ExtraValue {
+	extra := map[string]k8sAuthentication.ExtraValue{}
+	for key, value := range map[string]string{
+		extraName:           identity.Name,
+		extraExternalID:     identity.ExternalId,
+		extraExternalIDType: identity.ExternalIdType,
+		extraProfileURL:     identity.ProfileUrl,
+		extraProjectID:      identity.ProjectId,
+		extraRole:           identity.Role,
+	} {
+		if value != "" {
+			extra[key] = k8sAuthentication.ExtraValue{value}
+		}
 	}
+	return extra
 }
 
-func getEnvironmentIdentities(rancherClient *client.RancherClient) (map[string]client.ProjectMember, error) {
-	projects, err := rancherClient.Project.List(&client.ListOpts{})
-	if err != nil {
-		return nil, err
+// getEnvironmentIdentities returns the members of the environment among the
+// given identities, keyed by both member ID and identity ID. Members are
+// queried by the external IDs of the identities rather than listed in full,
+// and every page of the result is read. The go-rancher client cannot be
+// cancelled, so once the context is done the requests are left to finish in
+// the background.
+func getEnvironmentIdentities(ctx context.Context, rancherClient *client.RancherClient, environmentID string, identityIDs []string) (map[string]client.ProjectMember, error) {
+	if len(identityIDs) == 0 {
+		return map[string]client.ProjectMember{}, nil
+	}
+
+	type result struct {
+		projectMembersMap map[string]client.ProjectMember
+		err               error
+	}
+	results := make(chan result, 1)
+	go func() {
+		projectMembersMap, err := listEnvironmentIdentities(ctx, rancherClient, environmentID, identityIDs)
+		results <- result{projectMembersMap, err}
+	}()
+
+	select {
+	case r := <-results:
+		return r.projectMembersMap, r.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+func listEnvironmentIdentities(ctx context.Context, rancherClient *client.RancherClient, environmentID string, identityIDs []string) (map[string]client.ProjectMember, error) {
+	externalIDs := getExternalIDs(identityIDs)
+	if len(externalIDs) != len(identityIDs) {
+		return listProjectMembers(ctx, rancherClient, map[string]interface{}{
+			"projectId": environmentID,
+		})
+	}
+
+	// Users with many groups have long lists of external IDs, which are
+	// queried a chunk at a time to keep request lines short
+	projectMembersMap := map[string]client.ProjectMember{}
+	for start := 0; start < len(externalIDs); start += externalIDChunkSize {
+		end := start + externalIDChunkSize
+		if end > len(externalIDs) {
+			end = len(externalIDs)
+		}
+		chunk, err := listProjectMembers(ctx, rancherClient, map[string]interface{}{
+			"projectId":  environmentID,
+			"externalId": externalIDs[start:end],
+		})
+		if err != nil {
+			return nil, err
+		}
+		for key, projectMember := range chunk {
+			projectMembersMap[key] = projectMember
+		}
 	}
+	return projectMembersMap, nil
+}
+
+// listProjectMembers returns every page of the members matching the filters.
+func listProjectMembers(ctx context.Context, rancherClient *client.RancherClient, filters map[string]interface{}) (map[string]client.ProjectMember, error) {
+	projectMembersMap := map[string]client.ProjectMember{}
 
 	projectMembers, err := rancherClient.ProjectMember.List(&client.ListOpts{
-		Filters: map[string]interface{}{
-			"projectId": projects.Data[0].Id,
-		},
+		Filters: filters,
 	})
 	if err != nil {
 		return nil, err
 	}
 
-	projectMembersMap := map[string]client.ProjectMember{}
-	for _, projectMember := range projectMembers.Data {
-		projectMembersMap[projectMember.Id] = projectMember
+	for projectMembers != nil {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		for _, projectMember := range projectMembers.Data {
+			projectMembersMap[projectMember.Id] = projectMember
+			projectMembersMap[projectMember.ExternalIdType+":"+projectMember.ExternalId] = projectMember
+		}
+		if projectMembers, err = projectMembers.Next(); err != nil {
+			return nil, err
+		}
 	}
 
 	return projectMembersMap, nil
 }
 
-func shouldBeAuthenticated(identityCollection client.IdentityCollection, environmentIdentities map[string]client.ProjectMember) (bool, bool) {
-	authenticated := false
-	master := false
+// getExternalIDs returns the external IDs of identity IDs of the form
+// type:externalId, skipping any that are not.
+func getExternalIDs(identityIDs []string) []string {
+	var externalIDs []string
+	for _, id := range identityIDs {
+		if i := strings.Index(id, ":"); i > 0 {
+			externalIDs = append(externalIDs, id[i+1:])
+		}
+	}
+	return externalIDs
+}
 
-	for _, identity := range identityCollection.Data {
-		if environmentIdentity, ok := environmentIdentities[identity.Id]; ok {
-			authenticated = true
-			if environmentIdentity.Role == "owner" {
-				master = true
-				break
+// environmentRole returns the most privileged role held in the environment
+// by any of the identities, or an empty string if none of them are members.
+func environmentRole(identityIDs []string, environmentIdentities map[string]client.ProjectMember) string {
+	role := ""
+	for _, id := range identityIDs {
+		if environmentIdentity, ok := environmentIdentities[id]; ok {
+			if role == "" || rolePriority(environmentIdentity.Role) < rolePriority(role) {
+				role = environmentIdentity.Role
 			}
 		}
 	}
+	return role
+}
+
+func rolePriority(role string) int {
+	for i, r := range authorization.Roles {
+		if r == role {
+			return i
+		}
+	}
+	return len(authorization.Roles)
+}
+
+// roleCacheKey is the cache key of the role held by a set of identities, the
+// same whatever order they are given in. Token fingerprints are hex and so
+// never collide with it.
+func roleCacheKey(identityIDs []string) string {
+	sorted := append([]string{}, identityIDs...)
+	sort.Strings(sorted)
+	return "role:" + strings.Join(sorted, "\n")
+}
 
-	return authenticated, master
+func getIdentityIDs(identityCollection client.IdentityCollection) []string {
+	var ids []string
+	for _, identity := range identityCollection.Data {
+		ids = append(ids, identity.Id)
+	}
+	return ids
 }
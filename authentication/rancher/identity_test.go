@@ -0,0 +1,126 @@
+package rancherauthentication
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/rancher/go-rancher/v2"
+)
+
+// newRancherServer serves the members of environment 1a5 through the API
+// the go-rancher client uses, answering queries filtered by external ID and
+// recording the external IDs of every query. Other requests are passed to
+// handler, if there is one.
+func newRancherServer(t *testing.T, members []client.ProjectMember, handler http.HandlerFunc) (*httptest.Server, func() [][]string) {
+	var lock sync.Mutex
+	var queries [][]string
+
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v2-beta":
+			w.Header().Set("X-API-Schemas", server.URL+"/v2-beta/schemas")
+			w.Write([]byte(`{}`))
+		case "/v2-beta/schemas":
+			json.NewEncoder(w).Encode(client.Schemas{Data: []client.Schema{{
+				Resource: client.Resource{
+					Id:    client.PROJECT_MEMBER_TYPE,
+					Links: map[string]string{"collection": server.URL + "/v2-beta/projectmembers"},
+				},
+				CollectionMethods: []string{"GET"},
+			}}})
+		case "/v2-beta/projectmembers":
+			query := r.URL.Query()
+			if query.Get("projectId") != "1a5" {
+				t.Errorf("members listed for project %q", query.Get("projectId"))
+			}
+			externalIDs := query["externalId"]
+			lock.Lock()
+			queries = append(queries, externalIDs)
+			lock.Unlock()
+
+			var data []client.ProjectMember
+			for _, member := range members {
+				for _, externalID := range externalIDs {
+					if member.ExternalId == externalID {
+						data = append(data, member)
+					}
+				}
+			}
+			json.NewEncoder(w).Encode(client.ProjectMemberCollection{Data: data})
+		default:
+			if handler == nil {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			handler(w, r)
+		}
+	}))
+
+	return server, func() [][]string {
+		lock.Lock()
+		defer lock.Unlock()
+		return queries
+	}
+}
+
+func TestEnvironmentIdentitiesChunked(t *testing.T) {
+	identityIDs := []string{"ldap_user:uid=user,dc=example,dc=com"}
+	for i := 0; i < 2*externalIDChunkSize+5; i++ {
+		identityIDs = append(identityIDs, fmt.Sprintf("ldap_group:cn=group%d,ou=groups,dc=example,dc=com", i))
+	}
+	last := identityIDs[len(identityIDs)-1]
+
+	server, queries := newRancherServer(t, []client.ProjectMember{
+		{Resource: client.Resource{Id: "1pm1"}, ExternalIdType: "ldap_user", ExternalId: "uid=user,dc=example,dc=com", Role: "readonly"},
+		{Resource: client.Resource{Id: "1pm2"}, ExternalIdType: "ldap_group", ExternalId: last[len("ldap_group:"):], Role: "member"},
+	}, nil)
+	defer server.Close()
+
+	rancherClient, err := client.NewRancherClient(&client.ClientOpts{Url: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	environmentIdentities, err := getEnvironmentIdentities(context.Background(), rancherClient, "1a5", identityIDs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if role := environmentRole(identityIDs, environmentIdentities); role != "member" {
+		t.Errorf("got role %q, expected member", role)
+	}
+
+	queried := 0
+	for _, externalIDs := range queries() {
+		if len(externalIDs) > externalIDChunkSize {
+			t.Errorf("queried %d external IDs at once, expected at most %d", len(externalIDs), externalIDChunkSize)
+		}
+		queried += len(externalIDs)
+	}
+	if len(queries()) != 3 || queried != len(identityIDs) {
+		t.Errorf("queried %d external IDs in %d requests, expected %d in 3", queried, len(queries()), len(identityIDs))
+	}
+}
+
+func TestEnvironmentIdentitiesUnfiltered(t *testing.T) {
+	server, queries := newRancherServer(t, nil, nil)
+	defer server.Close()
+
+	rancherClient, err := client.NewRancherClient(&client.ClientOpts{Url: server.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Identity IDs without an external ID cannot be filtered on
+	if _, err := getEnvironmentIdentities(context.Background(), rancherClient, "1a5", []string{"ldap_user:a", "b"}); err != nil {
+		t.Fatal(err)
+	}
+	if q := queries(); len(q) != 1 || len(q[0]) != 0 {
+		t.Errorf("got queries %v, expected a single unfiltered one", q)
+	}
+}
@@ -0,0 +1,190 @@
+package rancherauthentication
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/v2"
+)
+
+const (
+	UsernameFromLogin      = "login"
+	UsernameFromName       = "name"
+	UsernameFromExternalID = "externalId"
+	UsernameFromRancherID  = "rancherId"
+
+	RewriteUser  = "user"
+	RewriteGroup = "group"
+
+	reservedPrefix = "system:"
+)
+
+// NameRules controls how user and group names are derived from Rancher
+// identities. Groups start out as externalIdType:login. Rewrites are applied
+// in order, then groups are filtered and finally prefixes are added. Names
+// that end up in the reserved system: namespace are rejected.
+type NameRules struct {
+	UsernameSource string    `json:"usernameSource"`
+	UsernamePrefix string    `json:"usernamePrefix"`
+	GroupPrefix    string    `json:"groupPrefix"`
+	Rewrites       []Rewrite `json:"rewrites"`
+	// AllowGroups and DenyGroups are regular expressions matched against
+	// the whole rewritten group name. When AllowGroups is set only groups
+	// matching it are kept.
+	AllowGroups []string `json:"allowGroups"`
+	DenyGroups  []string `json:"denyGroups"`
+}
+
+// Rewrite replaces matches of the regular expression Match with Replace,
+// which may refer to submatches as $1. Target limits it to user or group
+// names, it applies to both if empty.
+type Rewrite struct {
+	Match   string `json:"match"`
+	Replace string `json:"replace"`
+	Target  string `json:"target,omitempty"`
+}
+
+type rewrite struct {
+	match   *regexp.Regexp
+	replace string
+	target  string
+}
+
+type nameRules struct {
+	usernameSource string
+	usernamePrefix string
+	groupPrefix    string
+	rewrites       []rewrite
+	allowGroups    []*regexp.Regexp
+	denyGroups     []*regexp.Regexp
+}
+
+func compileNameRules(rules NameRules) (*nameRules, error) {
+	compiled := &nameRules{
+		usernameSource: rules.UsernameSource,
+		usernamePrefix: rules.UsernamePrefix,
+		groupPrefix:    rules.GroupPrefix,
+	}
+
+	switch compiled.usernameSource {
+	case "":
+		compiled.usernameSource = UsernameFromLogin
+	case UsernameFromLogin, UsernameFromName, UsernameFromExternalID, UsernameFromRancherID:
+	default:
+		return nil, fmt.Errorf("Invalid username source %s, expected one of %s, %s, %s or %s", rules.UsernameSource,
+			UsernameFromLogin, UsernameFromName, UsernameFromExternalID, UsernameFromRancherID)
+	}
+
+	for _, r := range rules.Rewrites {
+		switch r.Target {
+		case "", RewriteUser, RewriteGroup:
+		default:
+			return nil, fmt.Errorf("Invalid rewrite target %s, expected %s or %s", r.Target, RewriteUser, RewriteGroup)
+		}
+		match, err := regexp.Compile(r.Match)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid rewrite %s: %v", r.Match, err)
+		}
+		compiled.rewrites = append(compiled.rewrites, rewrite{
+			match:   match,
+			replace: r.Replace,
+			target:  r.Target,
+		})
+	}
+
+	var err error
+	if compiled.allowGroups, err = compileAnchored(rules.AllowGroups); err != nil {
+		return nil, err
+	}
+	if compiled.denyGroups, err = compileAnchored(rules.DenyGroups); err != nil {
+		return nil, err
+	}
+
+	return compiled, nil
+}
+
+func compileAnchored(patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile("^(?:" + pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("Invalid group filter %s: %v", pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// username returns the name of the user, or an empty string if the user has
+// no usable name.
+func (r *nameRules) username(identity, rancherIdentity client.Identity) string {
+	var name string
+	switch r.usernameSource {
+	case UsernameFromName:
+		name = identity.Name
+	case UsernameFromExternalID:
+		name = identity.ExternalId
+	case UsernameFromRancherID:
+		name = rancherIdentity.ExternalId
+	default:
+		name = identity.Login
+	}
+	if name == "" {
+		return ""
+	}
+
+	name = r.usernamePrefix + r.rewrite(RewriteUser, name)
+	if strings.HasPrefix(name, reservedPrefix) {
+		log.Warnf("Rejecting user %s, names starting with %s are reserved", name, reservedPrefix)
+		return ""
+	}
+	return name
+}
+
+// groups returns the names of the group identities, dropping any that are
+// filtered out or reserved.
+func (r *nameRules) groups(identities []client.Identity) []string {
+	var groups []string
+	for _, identity := range identities {
+		name := r.rewrite(RewriteGroup, fmt.Sprintf("%s:%s", identity.ExternalIdType, identity.Login))
+		if !r.allowed(name) {
+			log.Debugf("Group %s filtered out", name)
+			continue
+		}
+		name = r.groupPrefix + name
+		if strings.HasPrefix(name, reservedPrefix) {
+			log.Warnf("Dropping group %s, names starting with %s are reserved", name, reservedPrefix)
+			continue
+		}
+		groups = append(groups, name)
+	}
+	return groups
+}
+
+func (r *nameRules) rewrite(target, name string) string {
+	for _, rw := range r.rewrites {
+		if rw.target == "" || rw.target == target {
+			name = rw.match.ReplaceAllString(name, rw.replace)
+		}
+	}
+	return name
+}
+
+func (r *nameRules) allowed(group string) bool {
+	for _, re := range r.denyGroups {
+		if re.MatchString(group) {
+			return false
+		}
+	}
+	if len(r.allowGroups) == 0 {
+		return true
+	}
+	for _, re := range r.allowGroups {
+		if re.MatchString(group) {
+			return true
+		}
+	}
+	return false
+}
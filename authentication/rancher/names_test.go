@@ -0,0 +1,141 @@
+package rancherauthentication
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/rancher/go-rancher/v2"
+)
+
+func TestCompileNameRules(t *testing.T) {
+	tests := []struct {
+		name  string
+		rules NameRules
+		valid bool
+	}{
+		{"defaults", NameRules{}, true},
+		{"username source", NameRules{UsernameSource: UsernameFromExternalID}, true},
+		{"unknown username source", NameRules{UsernameSource: "email"}, false},
+		{"rewrite", NameRules{Rewrites: []Rewrite{{Match: "^ldap_group:", Target: RewriteGroup}}}, true},
+		{"unknown rewrite target", NameRules{Rewrites: []Rewrite{{Match: "a", Target: "both"}}}, false},
+		{"invalid rewrite", NameRules{Rewrites: []Rewrite{{Match: "("}}}, false},
+		{"invalid allow filter", NameRules{AllowGroups: []string{"("}}, false},
+		{"invalid deny filter", NameRules{DenyGroups: []string{"["}}, false},
+	}
+
+	for _, test := range tests {
+		_, err := compileNameRules(test.rules)
+		if (err == nil) != test.valid {
+			t.Errorf("%s: got error %v, expected valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestUsername(t *testing.T) {
+	identity := client.Identity{
+		Login:          "jdoe",
+		Name:           "Jane Doe",
+		ExternalId:     "uid=jdoe,dc=example,dc=com",
+		ExternalIdType: "ldap_user",
+	}
+	rancherIdentity := client.Identity{
+		ExternalId:     "1a7",
+		ExternalIdType: rancherIDType,
+	}
+
+	tests := []struct {
+		name     string
+		rules    NameRules
+		identity client.Identity
+		username string
+	}{
+		{"login by default", NameRules{}, identity, "jdoe"},
+		{"login", NameRules{UsernameSource: UsernameFromLogin}, identity, "jdoe"},
+		{"name", NameRules{UsernameSource: UsernameFromName}, identity, "Jane Doe"},
+		{"external ID", NameRules{UsernameSource: UsernameFromExternalID}, identity, "uid=jdoe,dc=example,dc=com"},
+		{"rancher ID", NameRules{UsernameSource: UsernameFromRancherID}, identity, "1a7"},
+		{"empty source", NameRules{UsernameSource: UsernameFromName}, client.Identity{Login: "jdoe"}, ""},
+		{"prefix", NameRules{UsernamePrefix: "ldap:"}, identity, "ldap:jdoe"},
+		{
+			"rewrites in order before prefix",
+			NameRules{
+				UsernamePrefix: "u:",
+				Rewrites: []Rewrite{
+					{Match: "^j(.*)$", Replace: "john_$1"},
+					{Match: "_", Replace: "-"},
+				},
+			},
+			identity,
+			"u:john-doe",
+		},
+		{"group rewrite skipped", NameRules{Rewrites: []Rewrite{{Match: "jdoe", Replace: "other", Target: RewriteGroup}}}, identity, "jdoe"},
+		{"user rewrite", NameRules{Rewrites: []Rewrite{{Match: "jdoe", Replace: "other", Target: RewriteUser}}}, identity, "other"},
+		{"reserved login", NameRules{}, client.Identity{Login: "system:admin"}, ""},
+		{"reserved after rewrite", NameRules{Rewrites: []Rewrite{{Match: "^", Replace: "system:"}}}, identity, ""},
+		{"reserved after prefix", NameRules{UsernamePrefix: "system:"}, identity, ""},
+	}
+
+	for _, test := range tests {
+		rules, err := compileNameRules(test.rules)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if username := rules.username(test.identity, rancherIdentity); username != test.username {
+			t.Errorf("%s: got username %q, expected %q", test.name, username, test.username)
+		}
+	}
+}
+
+func TestGroups(t *testing.T) {
+	identities := []client.Identity{
+		{Login: "devs", ExternalIdType: "ldap_group"},
+		{Login: "ops", ExternalIdType: "ldap_group"},
+		{Login: "devs", ExternalIdType: "github_team"},
+	}
+
+	tests := []struct {
+		name       string
+		rules      NameRules
+		identities []client.Identity
+		groups     []string
+	}{
+		{"defaults", NameRules{}, identities, []string{"ldap_group:devs", "ldap_group:ops", "github_team:devs"}},
+		{"prefix", NameRules{GroupPrefix: "rancher:"}, identities[:1], []string{"rancher:ldap_group:devs"}},
+		{
+			"rewrites in order",
+			NameRules{Rewrites: []Rewrite{
+				{Match: "^ldap_group:", Replace: "ldap:"},
+				{Match: "^ldap:(.*)$", Replace: "${1}-team"},
+			}},
+			identities[:2],
+			[]string{"devs-team", "ops-team"},
+		},
+		{"user rewrite skipped", NameRules{Rewrites: []Rewrite{{Match: "devs", Replace: "x", Target: RewriteUser}}}, identities[:1], []string{"ldap_group:devs"}},
+		{"allow", NameRules{AllowGroups: []string{"ldap_group:.*"}}, identities, []string{"ldap_group:devs", "ldap_group:ops"}},
+		{"allow anchored", NameRules{AllowGroups: []string{"devs"}}, identities, nil},
+		{"allow either", NameRules{AllowGroups: []string{"ldap_group:ops", "github_team:.*"}}, identities, []string{"ldap_group:ops", "github_team:devs"}},
+		{"deny", NameRules{DenyGroups: []string{".*:devs"}}, identities, []string{"ldap_group:ops"}},
+		{"deny anchored", NameRules{DenyGroups: []string{"ops"}}, identities, []string{"ldap_group:devs", "ldap_group:ops", "github_team:devs"}},
+		{"deny over allow", NameRules{AllowGroups: []string{"ldap_group:.*"}, DenyGroups: []string{"ldap_group:ops"}}, identities, []string{"ldap_group:devs"}},
+		{
+			"filters see rewritten names",
+			NameRules{Rewrites: []Rewrite{{Match: "^ldap_group:", Replace: "ldap:"}}, AllowGroups: []string{"ldap:.*"}},
+			identities,
+			[]string{"ldap:devs", "ldap:ops"},
+		},
+		{"filters before prefix", NameRules{GroupPrefix: "p:", AllowGroups: []string{"ldap_group:devs"}}, identities, []string{"p:ldap_group:devs"}},
+		{"reserved after rewrite", NameRules{Rewrites: []Rewrite{{Match: "^ldap_group:", Replace: "system:"}}}, identities, []string{"github_team:devs"}},
+		{"reserved after prefix", NameRules{GroupPrefix: "system:"}, identities[:1], nil},
+		{"reserved type", NameRules{}, []client.Identity{{Login: "masters", ExternalIdType: "system"}}, nil},
+	}
+
+	for _, test := range tests {
+		rules, err := compileNameRules(test.rules)
+		if err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if groups := rules.groups(test.identities); !reflect.DeepEqual(groups, test.groups) {
+			t.Errorf("%s: got groups %v, expected %v", test.name, groups, test.groups)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package rancherauthentication
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+const (
+	// IdentitySignatureExtra signs IdentityIDsExtra so that the identity
+	// IDs of a user can be trusted to come from this provider rather than
+	// from another provider of the union or a static token file
+	IdentitySignatureExtra = "rancher.io/identity-signature"
+
+	signatureKeyContext = "kubernetes-auth identity signature\n"
+)
+
+// newSignatureKey derives the key identity IDs are signed with from the
+// Rancher secret key, so that signatures stay valid across restarts and
+// between replicas sharing the key. Without a secret key a random key is
+// used.
+func newSignatureKey(secretKey string) ([]byte, error) {
+	if secretKey == "" {
+		key := make([]byte, sha256.Size)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+		return key, nil
+	}
+	sum := sha256.Sum256([]byte(signatureKeyContext + secretKey))
+	return sum[:], nil
+}
+
+func (p *Provider) signIdentityIDs(identityIDs []string) string {
+	mac := hmac.New(sha256.New, p.signatureKey)
+	mac.Write([]byte(strings.Join(identityIDs, "\n")))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// IdentityIDs returns the identity IDs in the extras of a user authenticated
+// by this provider, or nil if they are missing or not signed by it.
+func (p *Provider) IdentityIDs(extra map[string][]string) []string {
+	identityIDs := extra[IdentityIDsExtra]
+	signatures := extra[IdentitySignatureExtra]
+	if len(identityIDs) == 0 || len(signatures) != 1 {
+		return nil
+	}
+	signature, err := hex.DecodeString(signatures[0])
+	if err != nil {
+		return nil
+	}
+	expected, _ := hex.DecodeString(p.signIdentityIDs(identityIDs))
+	if !hmac.Equal(signature, expected) {
+		return nil
+	}
+	return identityIDs
+}
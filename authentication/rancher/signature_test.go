@@ -0,0 +1,63 @@
+package rancherauthentication
+
+import (
+	"testing"
+)
+
+func TestIdentityIDs(t *testing.T) {
+	p := &Provider{signatureKey: []byte("key")}
+	other := &Provider{signatureKey: []byte("other key")}
+	ids := []string{"ldap_user:cn=user", "ldap_group:cn=group"}
+
+	tests := []struct {
+		name     string
+		extra    map[string][]string
+		expected bool
+	}{
+		{"signed", map[string][]string{
+			IdentityIDsExtra:       ids,
+			IdentitySignatureExtra: {p.signIdentityIDs(ids)},
+		}, true},
+		{"unsigned", map[string][]string{
+			IdentityIDsExtra: ids,
+		}, false},
+		{"signed with another key", map[string][]string{
+			IdentityIDsExtra:       ids,
+			IdentitySignatureExtra: {other.signIdentityIDs(ids)},
+		}, false},
+		{"identity added", map[string][]string{
+			IdentityIDsExtra:       append([]string{"ldap_group:cn=admins"}, ids...),
+			IdentitySignatureExtra: {p.signIdentityIDs(ids)},
+		}, false},
+		{"several signatures", map[string][]string{
+			IdentityIDsExtra:       ids,
+			IdentitySignatureExtra: {p.signIdentityIDs(ids), p.signIdentityIDs(ids)},
+		}, false},
+		{"signature not hex", map[string][]string{
+			IdentityIDsExtra:       ids,
+			IdentitySignatureExtra: {"signature"},
+		}, false},
+		{"no identities", map[string][]string{
+			IdentitySignatureExtra: {p.signIdentityIDs(nil)},
+		}, false},
+	}
+
+	for _, test := range tests {
+		identityIDs := p.IdentityIDs(test.extra)
+		if found := identityIDs != nil; found != test.expected {
+			t.Errorf("%s: got identity IDs %v, expected trusted %v", test.name, identityIDs, test.expected)
+		}
+	}
+}
+
+func TestSignatureKey(t *testing.T) {
+	first, _ := newSignatureKey("secret")
+	second, _ := newSignatureKey("secret")
+	if string(first) != string(second) {
+		t.Error("keys derived from the same secret differ")
+	}
+	random, _ := newSignatureKey("")
+	if len(random) == 0 || string(random) == string(first) {
+		t.Error("no random key without a secret")
+	}
+}
@@ -0,0 +1,49 @@
+package authentication
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Factory creates a provider from its configuration, the JSON of the
+// provider's entry in the configuration file, or nil when none was given.
+type Factory func(config []byte) (Provider, error)
+
+var factories = map[string]Factory{}
+
+// Register makes a provider available by name. It is meant to be called from
+// the init function of the provider's package, so that importing the package
+// is enough to make the provider selectable.
+func Register(name string, factory Factory) {
+	if factory == nil {
+		panic("authentication: Register factory is nil for " + name)
+	}
+	if _, ok := factories[name]; ok {
+		panic("authentication: Register called twice for " + name)
+	}
+	factories[name] = factory
+}
+
+// New creates the provider registered under name.
+func New(name string, config []byte) (Provider, error) {
+	factory, ok := factories[name]
+	if !ok {
+		return nil, fmt.Errorf("Unknown authentication provider %s, expected one of %s", name, strings.Join(Names(), ", "))
+	}
+	provider, err := factory(config)
+	if err != nil {
+		return nil, fmt.Errorf("Failed to configure authentication provider %s: %v", name, err)
+	}
+	return provider, nil
+}
+
+// Names returns the names of all registered providers in sorted order.
+func Names() []string {
+	var names []string
+	for name := range factories {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,73 @@
+package authentication
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"k8s.io/client-go/pkg/apis/authentication"
+)
+
+type configProvider struct {
+	config string
+}
+
+func (c *configProvider) Lookup(token string) (*authentication.UserInfo, error) {
+	return nil, nil
+}
+
+func TestRegistry(t *testing.T) {
+	Register("registry-test", func(config []byte) (Provider, error) {
+		if string(config) == "invalid" {
+			return nil, errors.New("invalid config")
+		}
+		return &configProvider{config: string(config)}, nil
+	})
+
+	tests := []struct {
+		name     string
+		provider string
+		config   []byte
+		err      string
+	}{
+		{"no config", "registry-test", nil, ""},
+		{"config", "registry-test", []byte(`{"a":"b"}`), ""},
+		{"invalid config", "registry-test", []byte("invalid"), "Failed to configure authentication provider registry-test"},
+		{"unknown provider", "registry-unknown", nil, "Unknown authentication provider registry-unknown"},
+	}
+
+	for _, test := range tests {
+		provider, err := New(test.provider, test.config)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: error %v, expected %s", test.name, err, test.err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if provider.(*configProvider).config != string(test.config) {
+			t.Errorf("%s: factory got config %s", test.name, provider.(*configProvider).config)
+		}
+	}
+
+	found := false
+	for _, name := range Names() {
+		found = found || name == "registry-test"
+	}
+	if !found {
+		t.Errorf("registry-test missing from %v", Names())
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	factory := func(config []byte) (Provider, error) { return &configProvider{}, nil }
+	Register("registry-twice", factory)
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	Register("registry-twice", factory)
+}
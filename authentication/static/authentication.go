@@ -0,0 +1,220 @@
+package staticauthentication
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ghodss/yaml"
+	"github.com/rancher/kubernetes-auth/authentication"
+	"github.com/rancher/kubernetes-auth/filewatch"
+	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
+)
+
+// reloadInterval is how often the file is checked for changes, shortened in
+// tests
+var reloadInterval = 10 * time.Second
+
+func init() {
+	authentication.Register("static", newFromConfig)
+}
+
+type config struct {
+	Path string `json:"path"`
+}
+
+// Token is a single entry of a YAML or JSON token file.
+type Token struct {
+	Token  string              `json:"token"`
+	User   string              `json:"user"`
+	UID    string              `json:"uid,omitempty"`
+	Groups []string            `json:"groups,omitempty"`
+	Extra  map[string][]string `json:"extra,omitempty"`
+}
+
+type tokenFile struct {
+	Tokens []Token `json:"tokens"`
+}
+
+type entry struct {
+	hash     [sha256.Size]byte
+	userInfo k8sAuthentication.UserInfo
+}
+
+// Provider authenticates tokens listed in a file, either in the CSV format of
+// kube-apiserver's --token-auth-file or, for files ending in .yaml, .yml or
+// .json, as a list of tokens with extra attributes. The file is reloaded
+// when it changes or on SIGHUP.
+type Provider struct {
+	sync.RWMutex
+	path    string
+	entries []entry
+}
+
+func NewProvider(path string) (*Provider, error) {
+	p := &Provider{
+		path: path,
+	}
+	if err := p.load(); err != nil {
+		return nil, err
+	}
+
+	go filewatch.Watch(path, reloadInterval, func() {
+		if err := p.load(); err != nil {
+			log.Errorf("Failed to reload token file %s, keeping previous tokens: %v", path, err)
+		}
+	})
+
+	return p, nil
+}
+
+func newFromConfig(data []byte) (authentication.Provider, error) {
+	var cfg config
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("A token file path is required")
+	}
+	return NewProvider(cfg.Path)
+}
+
+// Lookup compares the token against every entry in constant time, so that
+// neither the position of a match nor a partial match can be timed.
+func (p *Provider) Lookup(token string) (*k8sAuthentication.UserInfo, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	hash := sha256.Sum256([]byte(token))
+
+	p.RLock()
+	defer p.RUnlock()
+
+	var match *entry
+	for i := range p.entries {
+		if subtle.ConstantTimeCompare(hash[:], p.entries[i].hash[:]) == 1 && match == nil {
+			match = &p.entries[i]
+		}
+	}
+	if match == nil {
+		return nil, nil
+	}
+
+	userInfo := match.userInfo
+	return &userInfo, nil
+}
+
+func (p *Provider) load() error {
+	data, err := ioutil.ReadFile(p.path)
+	if err != nil {
+		return err
+	}
+
+	var tokens []Token
+	switch strings.ToLower(filepath.Ext(p.path)) {
+	case ".yaml", ".yml", ".json":
+		tokens, err = parseYAML(data)
+	default:
+		tokens, err = parseCSV(data)
+	}
+	if err != nil {
+		return err
+	}
+
+	// As kube-apiserver does, records without a token are skipped and the
+	// last of any duplicate tokens wins
+	entries := make([]entry, 0, len(tokens))
+	indexes := map[[sha256.Size]byte]int{}
+	for i, token := range tokens {
+		if token.Token == "" {
+			log.Warnf("Skipping token %d in %s, it is empty", i+1, p.path)
+			continue
+		}
+		extra := map[string]k8sAuthentication.ExtraValue{}
+		for key, value := range token.Extra {
+			extra[key] = k8sAuthentication.ExtraValue(value)
+		}
+		e := entry{
+			hash: sha256.Sum256([]byte(token.Token)),
+			userInfo: k8sAuthentication.UserInfo{
+				Username: token.User,
+				UID:      token.UID,
+				Groups:   token.Groups,
+				Extra:    extra,
+			},
+		}
+		if index, ok := indexes[e.hash]; ok {
+			log.Warnf("Token %d in %s duplicates an earlier token, which it replaces", i+1, p.path)
+			entries[index] = e
+			continue
+		}
+		indexes[e.hash] = len(entries)
+		entries = append(entries, e)
+	}
+
+	p.Lock()
+	p.entries = entries
+	p.Unlock()
+
+	log.Infof("Loaded %d tokens from %s", len(entries), p.path)
+	return nil
+}
+
+func parseYAML(data []byte) ([]Token, error) {
+	var file tokenFile
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, err
+	}
+	return file.Tokens, nil
+}
+
+// parseCSV reads token,user,uid,"group1,group2" records as kube-apiserver
+// does, ignoring any further columns.
+func parseCSV(data []byte) ([]Token, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	reader.Comment = '#'
+
+	var tokens []Token
+	for n := 1; ; n++ {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if len(record) < 3 {
+			return nil, fmt.Errorf("Token file record %d has %d columns, expected at least 3", n, len(record))
+		}
+
+		token := Token{
+			Token: strings.TrimSpace(record[0]),
+			User:  strings.TrimSpace(record[1]),
+			UID:   strings.TrimSpace(record[2]),
+		}
+		if len(record) > 3 {
+			for _, group := range strings.Split(record[3], ",") {
+				if group = strings.TrimSpace(group); group != "" {
+					token.Groups = append(token.Groups, group)
+				}
+			}
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, nil
+}
@@ -0,0 +1,205 @@
+package staticauthentication
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	if err := ioutil.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	dir, err := ioutil.TempDir("", "static")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestLookup(t *testing.T) {
+	tests := []struct {
+		name  string
+		file  string
+		data  string
+		token string
+		user  *k8sAuthentication.UserInfo
+	}{
+		{
+			name:  "csv",
+			file:  "tokens.csv",
+			data:  "token1,user1,uid1\n",
+			token: "token1",
+			user:  &k8sAuthentication.UserInfo{Username: "user1", UID: "uid1", Extra: map[string]k8sAuthentication.ExtraValue{}},
+		},
+		{
+			name:  "csv quoted groups",
+			file:  "tokens.csv",
+			data:  "token1,user1,uid1,\"group1, group2\"\n",
+			token: "token1",
+			user: &k8sAuthentication.UserInfo{
+				Username: "user1",
+				UID:      "uid1",
+				Groups:   []string{"group1", "group2"},
+				Extra:    map[string]k8sAuthentication.ExtraValue{},
+			},
+		},
+		{
+			name:  "csv unknown token",
+			file:  "tokens.csv",
+			data:  "token1,user1,uid1\n",
+			token: "token2",
+		},
+		{
+			name:  "csv empty token skipped",
+			file:  "tokens.csv",
+			data:  ",user1,uid1\ntoken2,user2,uid2\n",
+			token: "token2",
+			user:  &k8sAuthentication.UserInfo{Username: "user2", UID: "uid2", Extra: map[string]k8sAuthentication.ExtraValue{}},
+		},
+		{
+			name:  "csv empty user",
+			file:  "tokens.csv",
+			data:  "token1,,uid1\n",
+			token: "token1",
+			user:  &k8sAuthentication.UserInfo{UID: "uid1", Extra: map[string]k8sAuthentication.ExtraValue{}},
+		},
+		{
+			name:  "csv last duplicate wins",
+			file:  "tokens.csv",
+			data:  "token1,user1,uid1\ntoken2,user2,uid2\ntoken1,user3,uid3\n",
+			token: "token1",
+			user:  &k8sAuthentication.UserInfo{Username: "user3", UID: "uid3", Extra: map[string]k8sAuthentication.ExtraValue{}},
+		},
+		{
+			name: "yaml",
+			file: "tokens.yaml",
+			data: `tokens:
+- token: token1
+  user: user1
+  uid: uid1
+  groups: [group1]
+  extra:
+    scopes: [read]
+`,
+			token: "token1",
+			user: &k8sAuthentication.UserInfo{
+				Username: "user1",
+				UID:      "uid1",
+				Groups:   []string{"group1"},
+				Extra:    map[string]k8sAuthentication.ExtraValue{"scopes": {"read"}},
+			},
+		},
+		{
+			name:  "json",
+			file:  "tokens.json",
+			data:  `{"tokens":[{"token":"token1","user":"user1"}]}`,
+			token: "token1",
+			user:  &k8sAuthentication.UserInfo{Username: "user1", Extra: map[string]k8sAuthentication.ExtraValue{}},
+		},
+		{
+			name:  "empty token never matches",
+			file:  "tokens.csv",
+			data:  ",user1,uid1\n",
+			token: "",
+		},
+	}
+
+	for _, test := range tests {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, test.file)
+		writeFile(t, path, test.data)
+
+		p, err := NewProvider(path)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		user, err := p.Lookup(test.token)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		if !reflect.DeepEqual(user, test.user) {
+			t.Errorf("%s: got %+v, expected %+v", test.name, user, test.user)
+		}
+	}
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		data string
+	}{
+		{"too few columns", "tokens.csv", "token1,user1\n"},
+		{"unterminated quote", "tokens.csv", "token1,user1,uid1,\"group1\n"},
+		{"invalid yaml", "tokens.yaml", "tokens: ["},
+	}
+
+	for _, test := range tests {
+		dir := tempDir(t)
+		defer os.RemoveAll(dir)
+		path := filepath.Join(dir, test.file)
+		writeFile(t, path, test.data)
+
+		if _, err := NewProvider(path); err == nil {
+			t.Errorf("%s: loaded", test.name)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	previous := reloadInterval
+	reloadInterval = 10 * time.Millisecond
+	defer func() { reloadInterval = previous }()
+
+	dir := tempDir(t)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "tokens.csv")
+	writeFile(t, path, "token1,user1,uid1\n")
+
+	p, err := NewProvider(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookup := func(token string) string {
+		user, _ := p.Lookup(token)
+		if user == nil {
+			return ""
+		}
+		return user.Username
+	}
+
+	// Give the watcher time to record the initial state, and change the size
+	// as well as the contents so that the change is seen whatever the
+	// resolution of modification times
+	time.Sleep(100 * time.Millisecond)
+	writeFile(t, path, "token2,user2,uid2\n# changed\n")
+	deadline := time.Now().Add(5 * time.Second)
+	for lookup("token2") == "" && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if username := lookup("token2"); username != "user2" {
+		t.Errorf("new token authenticated as %q", username)
+	}
+	if username := lookup("token1"); username != "" {
+		t.Errorf("removed token authenticated as %q", username)
+	}
+
+	// An invalid file keeps the previous tokens
+	writeFile(t, path, "token3,user3\n")
+	time.Sleep(100 * time.Millisecond)
+	if username := lookup("token2"); username != "user2" {
+		t.Errorf("token lost after invalid reload, authenticated as %q", username)
+	}
+}
@@ -1,6 +1,29 @@
 package testauthentication
 
-import k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"time"
+
+	"github.com/ghodss/yaml"
+	"github.com/rancher/kubernetes-auth/authentication"
+	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
+)
+
+const (
+	BehaviourExpired = "expired"
+	BehaviourError   = "error"
+	BehaviourSlow    = "slow"
+
+	defaultDelay = 5 * time.Second
+)
+
+func init() {
+	authentication.Register("test", newFromConfig)
+}
 
 var (
 	testUserInfo = map[string]k8sAuthentication.UserInfo{
@@ -19,12 +42,152 @@ var (
 	}
 )
 
-type Provider struct{}
+// User is a fixture for a single token. Behaviour makes the token expired,
+// fail as if the backend were unavailable, or answer after a delay.
+type User struct {
+	Token     string              `json:"token"`
+	Username  string              `json:"username"`
+	UID       string              `json:"uid,omitempty"`
+	Groups    []string            `json:"groups,omitempty"`
+	Extra     map[string][]string `json:"extra,omitempty"`
+	Behaviour string              `json:"behaviour,omitempty"`
+	Delay     string              `json:"delay,omitempty"`
+	Error     string              `json:"error,omitempty"`
+}
+
+type Fixtures struct {
+	Users []User `json:"users"`
+}
+
+// config selects a fixture file, or gives the users inline.
+type config struct {
+	File  string `json:"file"`
+	Users []User `json:"users"`
+}
+
+type fixture struct {
+	userInfo  k8sAuthentication.UserInfo
+	behaviour string
+	delay     time.Duration
+	err       string
+}
+
+// Provider authenticates a fixed set of test users, the four built in ones
+// unless fixtures are loaded from a file.
+type Provider struct {
+	fixtures map[string]fixture
+}
+
+func newFromConfig(data []byte) (authentication.Provider, error) {
+	var cfg config
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &cfg); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.File != "" {
+		return NewProvider(cfg.File)
+	}
+	if cfg.Users != nil {
+		return newProviderFromFixtures(Fixtures{Users: cfg.Users})
+	}
+	return &Provider{}, nil
+}
+
+// NewProvider loads fixtures from a YAML or JSON file.
+func NewProvider(fixtureFile string) (*Provider, error) {
+	data, err := ioutil.ReadFile(fixtureFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var fixtures Fixtures
+	if err := yaml.Unmarshal(data, &fixtures); err != nil {
+		return nil, fmt.Errorf("Failed to parse test fixtures %s: %v", fixtureFile, err)
+	}
+
+	return newProviderFromFixtures(fixtures)
+}
+
+func newProviderFromFixtures(fixtures Fixtures) (*Provider, error) {
+	p := &Provider{
+		fixtures: map[string]fixture{},
+	}
+	for _, user := range fixtures.Users {
+		if user.Token == "" {
+			return nil, fmt.Errorf("Test user %s has no token", user.Username)
+		}
+
+		f := fixture{
+			userInfo: k8sAuthentication.UserInfo{
+				Username: user.Username,
+				UID:      user.UID,
+				Groups:   user.Groups,
+				Extra:    map[string]k8sAuthentication.ExtraValue{},
+			},
+			behaviour: user.Behaviour,
+			delay:     defaultDelay,
+			err:       user.Error,
+		}
+		for key, value := range user.Extra {
+			f.userInfo.Extra[key] = k8sAuthentication.ExtraValue(value)
+		}
+
+		switch user.Behaviour {
+		case "", BehaviourExpired, BehaviourError:
+		case BehaviourSlow:
+			if user.Delay != "" {
+				var err error
+				if f.delay, err = time.ParseDuration(user.Delay); err != nil {
+					return nil, fmt.Errorf("Invalid delay for test user %s: %v", user.Username, err)
+				}
+			}
+		default:
+			return nil, fmt.Errorf("Unknown behaviour %s for test user %s", user.Behaviour, user.Username)
+		}
+
+		p.fixtures[user.Token] = f
+	}
+
+	return p, nil
+}
 
 func (p *Provider) Lookup(token string) (*k8sAuthentication.UserInfo, error) {
-	userInfo, ok := testUserInfo[token]
+	return p.LookupContext(context.Background(), token)
+}
+
+// LookupContext gives up waiting for slow tokens when the context is done.
+func (p *Provider) LookupContext(ctx context.Context, token string) (*k8sAuthentication.UserInfo, error) {
+	if p.fixtures == nil {
+		userInfo, ok := testUserInfo[token]
+		if !ok {
+			return nil, nil
+		}
+		return &userInfo, nil
+	}
+
+	f, ok := p.fixtures[token]
 	if !ok {
 		return nil, nil
 	}
+
+	switch f.behaviour {
+	case BehaviourExpired:
+		return nil, authentication.NewInvalidTokenError("token of %s has expired", f.userInfo.Username)
+	case BehaviourError:
+		message := f.err
+		if message == "" {
+			message = "simulated failure"
+		}
+		return nil, authentication.NewUnavailableError("test", errors.New(message))
+	case BehaviourSlow:
+		select {
+		case <-time.After(f.delay):
+		case <-ctx.Done():
+			return nil, authentication.ContextError(ctx)
+		}
+	}
+
+	userInfo := f.userInfo
 	return &userInfo, nil
 }
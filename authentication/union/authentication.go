@@ -0,0 +1,89 @@
+package unionauthentication
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/kubernetes-auth/authentication"
+	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
+)
+
+// ErrorPolicy decides what happens when a member fails to check a token.
+type ErrorPolicy string
+
+const (
+	// StopOnError fails the lookup with the member's error
+	StopOnError ErrorPolicy = "stop"
+	// ContinueOnError falls through to the next member
+	ContinueOnError ErrorPolicy = "continue"
+)
+
+type Member struct {
+	Name     string
+	Provider authentication.Provider
+	OnError  ErrorPolicy
+}
+
+// Provider tries each member in order and returns the first user found. A
+// member rejecting a token as invalid never stops the chain, the token may
+// belong to a later member.
+type Provider struct {
+	members []Member
+}
+
+func ParseErrorPolicy(policy string) (ErrorPolicy, error) {
+	switch ErrorPolicy(policy) {
+	case StopOnError, ContinueOnError:
+		return ErrorPolicy(policy), nil
+	case "":
+		return StopOnError, nil
+	}
+	return "", fmt.Errorf("Invalid provider error policy %s, expected %s or %s", policy, StopOnError, ContinueOnError)
+}
+
+func NewProvider(members ...Member) *Provider {
+	return &Provider{
+		members: members,
+	}
+}
+
+func (p *Provider) Lookup(token string) (*k8sAuthentication.UserInfo, error) {
+	return p.LookupContext(context.Background(), token)
+}
+
+// LookupContext stops trying members once the context is done.
+func (p *Provider) LookupContext(ctx context.Context, token string) (*k8sAuthentication.UserInfo, error) {
+	var lookupErr error
+	for _, member := range p.members {
+		if err := authentication.ContextError(ctx); err != nil {
+			return nil, err
+		}
+		userInfo, err := authentication.LookupContext(ctx, member.Provider, token)
+		if err != nil {
+			if authentication.IsInvalidToken(err) {
+				log.Debugf("Provider %s rejected token: %v", member.Name, err)
+				if lookupErr == nil {
+					lookupErr = err
+				}
+				continue
+			}
+			if member.OnError != ContinueOnError {
+				return nil, err
+			}
+			log.Warnf("Provider %s failed, trying the next provider: %v", member.Name, err)
+			// A failure hides whether the token was valid, so it takes
+			// precedence over any rejection
+			if lookupErr == nil || authentication.IsInvalidToken(lookupErr) {
+				lookupErr = err
+			}
+			continue
+		}
+		if userInfo != nil {
+			log.Debugf("Provider %s authenticated %s", member.Name, userInfo.Username)
+			return userInfo, nil
+		}
+	}
+
+	return nil, lookupErr
+}
@@ -0,0 +1,166 @@
+package unionauthentication
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/rancher/kubernetes-auth/authentication"
+	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
+)
+
+type fakeProvider struct {
+	users map[string]string
+	err   error
+	calls int
+}
+
+func (f *fakeProvider) Lookup(token string) (*k8sAuthentication.UserInfo, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	if username, ok := f.users[token]; ok {
+		return &k8sAuthentication.UserInfo{Username: username}, nil
+	}
+	return nil, nil
+}
+
+func TestLookup(t *testing.T) {
+	unavailable := authentication.NewUnavailableError("backend", errors.New("down"))
+	invalid := authentication.NewInvalidTokenError("revoked")
+
+	tests := []struct {
+		name        string
+		members     func() []Member
+		username    string
+		invalid     bool
+		unavailable bool
+	}{
+		{
+			name: "first match wins",
+			members: func() []Member {
+				return []Member{
+					{Name: "a", Provider: &fakeProvider{users: map[string]string{"token": "a"}}},
+					{Name: "b", Provider: &fakeProvider{users: map[string]string{"token": "b"}}},
+				}
+			},
+			username: "a",
+		},
+		{
+			name: "falls through unknown tokens",
+			members: func() []Member {
+				return []Member{
+					{Name: "a", Provider: &fakeProvider{}},
+					{Name: "b", Provider: &fakeProvider{users: map[string]string{"token": "b"}}},
+				}
+			},
+			username: "b",
+		},
+		{
+			name: "falls through invalid tokens",
+			members: func() []Member {
+				return []Member{
+					{Name: "a", Provider: &fakeProvider{err: invalid}},
+					{Name: "b", Provider: &fakeProvider{users: map[string]string{"token": "b"}}},
+				}
+			},
+			username: "b",
+		},
+		{
+			name: "invalid when no member matches",
+			members: func() []Member {
+				return []Member{
+					{Name: "a", Provider: &fakeProvider{err: invalid}},
+					{Name: "b", Provider: &fakeProvider{}},
+				}
+			},
+			invalid: true,
+		},
+		{
+			name: "error stops the chain",
+			members: func() []Member {
+				return []Member{
+					{Name: "a", Provider: &fakeProvider{err: unavailable}, OnError: StopOnError},
+					{Name: "b", Provider: &fakeProvider{users: map[string]string{"token": "b"}}},
+				}
+			},
+			unavailable: true,
+		},
+		{
+			name: "error continues the chain",
+			members: func() []Member {
+				return []Member{
+					{Name: "a", Provider: &fakeProvider{err: unavailable}, OnError: ContinueOnError},
+					{Name: "b", Provider: &fakeProvider{users: map[string]string{"token": "b"}}},
+				}
+			},
+			username: "b",
+		},
+		{
+			name: "error outranks rejection",
+			members: func() []Member {
+				return []Member{
+					{Name: "a", Provider: &fakeProvider{err: invalid}},
+					{Name: "b", Provider: &fakeProvider{err: unavailable}, OnError: ContinueOnError},
+					{Name: "c", Provider: &fakeProvider{}},
+				}
+			},
+			unavailable: true,
+		},
+		{
+			name:    "no members",
+			members: func() []Member { return nil },
+		},
+	}
+
+	for _, test := range tests {
+		userInfo, err := NewProvider(test.members()...).Lookup("token")
+
+		switch {
+		case test.invalid != authentication.IsInvalidToken(err):
+			t.Errorf("%s: error %v, expected invalid token %v", test.name, err, test.invalid)
+		case test.unavailable != authentication.IsUnavailable(err):
+			t.Errorf("%s: error %v, expected unavailable %v", test.name, err, test.unavailable)
+		case test.username == "" && userInfo != nil:
+			t.Errorf("%s: authenticated %+v", test.name, userInfo)
+		case test.username != "" && (userInfo == nil || userInfo.Username != test.username):
+			t.Errorf("%s: got %+v, expected %s", test.name, userInfo, test.username)
+		}
+	}
+}
+
+func TestLookupContextDone(t *testing.T) {
+	member := &fakeProvider{users: map[string]string{"token": "a"}}
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	<-ctx.Done()
+
+	if _, err := NewProvider(Member{Name: "a", Provider: member}).LookupContext(ctx, "token"); !authentication.IsUnavailable(err) {
+		t.Errorf("error %v, expected unavailable", err)
+	}
+	if member.calls != 0 {
+		t.Errorf("member called %d times after the context was done", member.calls)
+	}
+}
+
+func TestParseErrorPolicy(t *testing.T) {
+	tests := []struct {
+		policy   string
+		expected ErrorPolicy
+		valid    bool
+	}{
+		{"", StopOnError, true},
+		{"stop", StopOnError, true},
+		{"continue", ContinueOnError, true},
+		{"ignore", "", false},
+	}
+
+	for _, test := range tests {
+		policy, err := ParseErrorPolicy(test.policy)
+		if valid := err == nil; valid != test.valid || policy != test.expected {
+			t.Errorf("%q: got %s, %v", test.policy, policy, err)
+		}
+	}
+}
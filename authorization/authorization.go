@@ -0,0 +1,26 @@
+package authorization
+
+import (
+	"context"
+)
+
+// Attributes describes a single request the apiserver asks to authorize.
+type Attributes struct {
+	User   string
+	UID    string
+	Groups []string
+	Extra  map[string][]string
+
+	ResourceRequest bool
+	Verb            string
+	Namespace       string
+	APIGroup        string
+	Resource        string
+	Subresource     string
+	Name            string
+	Path            string
+}
+
+type Authorizer interface {
+	Authorize(ctx context.Context, attributes Attributes) (allowed bool, reason string, err error)
+}
@@ -0,0 +1,172 @@
+package authorization
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/ghodss/yaml"
+)
+
+const all = "*"
+
+// Policy maps Rancher environment roles to the requests they allow.
+type Policy struct {
+	Roles map[string][]Rule `json:"roles"`
+}
+
+// Rule allows a set of verbs on either resources or non-resource URLs. A rule
+// without namespaces applies to every namespace and to cluster scoped
+// resources, "*" only matches namespaced requests.
+type Rule struct {
+	Verbs           []string `json:"verbs"`
+	APIGroups       []string `json:"apiGroups,omitempty"`
+	Resources       []string `json:"resources,omitempty"`
+	Namespaces      []string `json:"namespaces,omitempty"`
+	NonResourceURLs []string `json:"nonResourceURLs,omitempty"`
+}
+
+// Roles are the Rancher environment roles a policy can give rules to, most
+// privileged first.
+var Roles = []string{"owner", "member", "restricted", "readonly"}
+
+var (
+	readVerbs = []string{"get", "list", "watch"}
+
+	// userNamespaces are the namespaces roles other than owner may use,
+	// leaving kube-system and the other system namespaces to owners
+	userNamespaces = []string{"default"}
+
+	// workloadResources are the namespaced resources used to run
+	// applications, secrets are deliberately left out
+	workloadResources = []string{
+		"pods", "pods/log", "pods/status", "services", "endpoints", "configmaps",
+		"persistentvolumeclaims", "replicationcontrollers", "replicationcontrollers/scale",
+		"deployments", "deployments/scale", "deployments/rollback", "replicasets", "replicasets/scale",
+		"daemonsets", "statefulsets", "jobs", "cronjobs", "ingresses",
+		"horizontalpodautoscalers", "events",
+	}
+
+	// podAccessResources reach into running containers
+	podAccessResources = []string{"pods/exec", "pods/attach", "pods/portforward"}
+
+	// clusterResources are cluster scoped resources safe to read
+	clusterResources = []string{"namespaces", "nodes", "persistentvolumes", "storageclasses"}
+
+	// discoveryURLs are the non-resource URLs clients need to find the API
+	discoveryURLs = []string{"/api", "/api/*", "/apis", "/apis/*", "/version", "/healthz", "/swaggerapi/*", "/swagger.json"}
+
+	DefaultPolicy = Policy{
+		Roles: map[string][]Rule{
+			"owner": {
+				{Verbs: []string{all}, APIGroups: []string{all}, Resources: []string{all}},
+				{Verbs: []string{all}, NonResourceURLs: []string{all}},
+			},
+			"member": {
+				{Verbs: []string{all}, APIGroups: []string{all}, Resources: workloadResources, Namespaces: userNamespaces},
+				{Verbs: []string{all}, APIGroups: []string{all}, Resources: podAccessResources, Namespaces: userNamespaces},
+				{Verbs: readVerbs, APIGroups: []string{all}, Resources: clusterResources},
+				{Verbs: []string{"get"}, NonResourceURLs: discoveryURLs},
+			},
+			"restricted": {
+				{Verbs: []string{all}, APIGroups: []string{all}, Resources: workloadResources, Namespaces: userNamespaces},
+				{Verbs: []string{"get"}, NonResourceURLs: discoveryURLs},
+			},
+			"readonly": {
+				{Verbs: readVerbs, APIGroups: []string{all}, Resources: workloadResources, Namespaces: userNamespaces},
+				{Verbs: []string{"get"}, NonResourceURLs: discoveryURLs},
+			},
+		},
+	}
+)
+
+func LoadPolicy(path string) (*Policy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var policy Policy
+	if err := yaml.Unmarshal(data, &policy); err != nil {
+		return nil, err
+	}
+	if err := policy.validate(); err != nil {
+		return nil, fmt.Errorf("Invalid authorization policy %s: %v", path, err)
+	}
+
+	return &policy, nil
+}
+
+// validate rejects rules for roles Rancher does not have, which would
+// otherwise silently never apply.
+func (p *Policy) validate() error {
+	var unknown []string
+	for role := range p.Roles {
+		known := false
+		for _, r := range Roles {
+			if r == role {
+				known = true
+			}
+		}
+		if !known {
+			unknown = append(unknown, role)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown roles %s, expected %s", strings.Join(unknown, ", "), strings.Join(Roles, ", "))
+	}
+	return nil
+}
+
+func (p *Policy) Allows(role string, attributes Attributes) bool {
+	for _, rule := range p.Roles[role] {
+		if rule.matches(attributes) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r Rule) matches(attributes Attributes) bool {
+	if !contains(r.Verbs, attributes.Verb) {
+		return false
+	}
+
+	if !attributes.ResourceRequest {
+		for _, url := range r.NonResourceURLs {
+			if url == all || url == attributes.Path ||
+				(strings.HasSuffix(url, all) && strings.HasPrefix(attributes.Path, strings.TrimSuffix(url, all))) {
+				return true
+			}
+		}
+		return false
+	}
+
+	if len(r.Resources) == 0 || !contains(r.APIGroups, attributes.APIGroup) {
+		return false
+	}
+
+	resource := attributes.Resource
+	if attributes.Subresource != "" {
+		resource += "/" + attributes.Subresource
+	}
+	if !contains(r.Resources, resource) {
+		return false
+	}
+
+	if len(r.Namespaces) == 0 {
+		return true
+	}
+	return attributes.Namespace != "" && contains(r.Namespaces, attributes.Namespace)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == all || v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package authorization
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDefaultPolicy(t *testing.T) {
+	pod := func(verb, namespace string) Attributes {
+		return Attributes{ResourceRequest: true, Verb: verb, Resource: "pods", Namespace: namespace}
+	}
+
+	tests := []struct {
+		name       string
+		attributes Attributes
+		allowed    map[string]bool
+	}{
+		{
+			name:       "create pod in default",
+			attributes: pod("create", "default"),
+			allowed:    map[string]bool{"owner": true, "member": true, "restricted": true},
+		},
+		{
+			name:       "list pods in default",
+			attributes: pod("list", "default"),
+			allowed:    map[string]bool{"owner": true, "member": true, "restricted": true, "readonly": true},
+		},
+		{
+			name:       "create pod in kube-system",
+			attributes: pod("create", "kube-system"),
+			allowed:    map[string]bool{"owner": true},
+		},
+		{
+			name:       "list pods in kube-system",
+			attributes: pod("list", "kube-system"),
+			allowed:    map[string]bool{"owner": true},
+		},
+		{
+			name:       "get secret in default",
+			attributes: Attributes{ResourceRequest: true, Verb: "get", Resource: "secrets", Namespace: "default"},
+			allowed:    map[string]bool{"owner": true},
+		},
+		{
+			name:       "list secrets in all namespaces",
+			attributes: Attributes{ResourceRequest: true, Verb: "list", Resource: "secrets"},
+			allowed:    map[string]bool{"owner": true},
+		},
+		{
+			name:       "exec into pod in default",
+			attributes: Attributes{ResourceRequest: true, Verb: "create", Resource: "pods", Subresource: "exec", Namespace: "default"},
+			allowed:    map[string]bool{"owner": true, "member": true},
+		},
+		{
+			name:       "list nodes",
+			attributes: Attributes{ResourceRequest: true, Verb: "list", Resource: "nodes"},
+			allowed:    map[string]bool{"owner": true, "member": true},
+		},
+		{
+			name:       "delete node",
+			attributes: Attributes{ResourceRequest: true, Verb: "delete", Resource: "nodes"},
+			allowed:    map[string]bool{"owner": true},
+		},
+		{
+			name:       "create role binding in default",
+			attributes: Attributes{ResourceRequest: true, Verb: "create", APIGroup: "rbac.authorization.k8s.io", Resource: "rolebindings", Namespace: "default"},
+			allowed:    map[string]bool{"owner": true},
+		},
+		{
+			name:       "discovery",
+			attributes: Attributes{Verb: "get", Path: "/apis/apps"},
+			allowed:    map[string]bool{"owner": true, "member": true, "restricted": true, "readonly": true},
+		},
+		{
+			name:       "profiling",
+			attributes: Attributes{Verb: "get", Path: "/debug/pprof/profile"},
+			allowed:    map[string]bool{"owner": true},
+		},
+	}
+
+	for _, test := range tests {
+		for _, role := range append(Roles, "unknown") {
+			if allowed := DefaultPolicy.Allows(role, test.attributes); allowed != test.allowed[role] {
+				t.Errorf("%s: %s allowed %v, expected %v", test.name, role, allowed, test.allowed[role])
+			}
+		}
+	}
+}
+
+func TestLoadPolicy(t *testing.T) {
+	tests := []struct {
+		name   string
+		policy string
+		err    string
+	}{
+		{"known roles", "roles:\n  owner: []\n  readonly: []\n", ""},
+		{"unknown role", "roles:\n  admin: []\n  member: []\n", "unknown roles admin"},
+		{"not yaml", "roles: [", "yaml"},
+	}
+
+	dir, err := ioutil.TempDir("", "policy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for i, test := range tests {
+		path := filepath.Join(dir, fmt.Sprintf("%d.yaml", i))
+		if err := ioutil.WriteFile(path, []byte(test.policy), 0600); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadPolicy(path)
+		switch {
+		case test.err == "" && err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		case test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)):
+			t.Errorf("%s: error %v, expected %s", test.name, err, test.err)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package rancherauthorization
+
+import (
+	"context"
+	"fmt"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/kubernetes-auth/authentication/rancher"
+	"github.com/rancher/kubernetes-auth/authorization"
+)
+
+type Authorizer struct {
+	provider *rancherauthentication.Provider
+	policy   *authorization.Policy
+}
+
+func NewAuthorizer(provider *rancherauthentication.Provider, policy *authorization.Policy) *Authorizer {
+	return &Authorizer{
+		provider: provider,
+		policy:   policy,
+	}
+}
+
+// Authorize allows requests permitted by the policy for the most privileged
+// role the user, or any of their groups, holds in the Rancher environment.
+// Only users authenticated by the Rancher provider, whose identity IDs it
+// signed, are considered. Requests are never explicitly denied so that
+// other authorizers, such as RBAC, still get a say.
+func (a *Authorizer) Authorize(ctx context.Context, attributes authorization.Attributes) (bool, string, error) {
+	identityIDs := a.provider.IdentityIDs(attributes.Extra)
+	if len(identityIDs) == 0 {
+		return false, "no Rancher identity", nil
+	}
+
+	role, err := a.provider.EnvironmentRole(ctx, identityIDs)
+	if err != nil {
+		return false, "", err
+	}
+	if role == "" {
+		log.Debugf("User %s is not a member of the environment", attributes.User)
+		return false, "not a member of the Rancher environment", nil
+	}
+
+	if !a.policy.Allows(role, attributes) {
+		log.Debugf("Environment role %s of user %s does not allow %+v", role, attributes.User, attributes)
+		return false, fmt.Sprintf("Rancher environment role %s does not allow this request", role), nil
+	}
+
+	return true, fmt.Sprintf("allowed by Rancher environment role %s", role), nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/kubernetes-auth/authentication/rancher"
+	"github.com/rancher/kubernetes-auth/credential"
+	"github.com/urfave/cli"
+)
+
+func credentialCommand() cli.Command {
+	return cli.Command{
+		Name:   "credential",
+		Usage:  "Print an ExecCredential for a Rancher API key, for use as a kubectl exec credential plugin",
+		Action: runCredential,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "api-key-file",
+				Usage:  "File holding the Rancher API key as ACCESS_KEY:SECRET_KEY, or as YAML or JSON with accessKey and secretKey",
+				EnvVar: "RANCHER_API_KEY_FILE",
+			},
+			cli.StringFlag{
+				Name:   "access-key",
+				Usage:  "Rancher API access key, when no API key file is given",
+				EnvVar: "RANCHER_ACCESS_KEY",
+			},
+			cli.StringFlag{
+				Name:   "secret-key",
+				Usage:  "Rancher API secret key, when no API key file is given",
+				EnvVar: "RANCHER_SECRET_KEY",
+			},
+			cli.StringFlag{
+				Name:   "cache-dir",
+				Value:  filepath.Join(os.Getenv("HOME"), ".kube", "cache", "kubernetes-auth"),
+				Usage:  "Directory to cache credential expiry times in",
+				EnvVar: "KUBERNETES_AUTH_CACHE_DIR",
+			},
+			cli.DurationFlag{
+				Name:   "ttl",
+				Value:  time.Hour,
+				Usage:  "How long kubectl may reuse a credential before asking again, 0 disables caching",
+				EnvVar: "KUBERNETES_AUTH_CREDENTIAL_TTL",
+			},
+		},
+	}
+}
+
+func runCredential(c *cli.Context) error {
+	apiKey := &credential.APIKey{
+		AccessKey: c.String("access-key"),
+		SecretKey: c.String("secret-key"),
+	}
+	if apiKeyFile := c.String("api-key-file"); apiKeyFile != "" {
+		var err error
+		if apiKey, err = credential.LoadAPIKey(apiKeyFile); err != nil {
+			return err
+		}
+	}
+	if apiKey.AccessKey == "" || apiKey.SecretKey == "" {
+		return fmt.Errorf("A Rancher API key is required, use --api-key-file or --access-key and --secret-key")
+	}
+
+	ttl := c.Duration("ttl")
+	if ttl <= 0 {
+		token := rancherauthentication.EncodeToken(apiKey.AccessKey, apiKey.SecretKey)
+		return json.NewEncoder(os.Stdout).Encode(credential.New(token, time.Time{}))
+	}
+
+	cache := credential.NewCache(c.String("cache-dir"))
+	expiry, ok := cache.Get(apiKey.AccessKey)
+	if !ok {
+		expiry = time.Now().Add(ttl)
+		if err := cache.Put(apiKey.AccessKey, expiry); err != nil {
+			log.Warnf("Failed to cache credential expiry: %v", err)
+		}
+	}
+
+	token := rancherauthentication.EncodeToken(apiKey.AccessKey, apiKey.SecretKey)
+	return json.NewEncoder(os.Stdout).Encode(credential.New(token, expiry))
+}
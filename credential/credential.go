@@ -0,0 +1,157 @@
+package credential
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ghodss/yaml"
+)
+
+const (
+	APIVersion = "client.authentication.k8s.io/v1beta1"
+	Kind       = "ExecCredential"
+
+	execInfoEnv = "KUBERNETES_EXEC_INFO"
+)
+
+type ExecCredential struct {
+	APIVersion string               `json:"apiVersion"`
+	Kind       string               `json:"kind"`
+	Status     ExecCredentialStatus `json:"status"`
+}
+
+type ExecCredentialStatus struct {
+	Token               string `json:"token"`
+	ExpirationTimestamp string `json:"expirationTimestamp,omitempty"`
+}
+
+type APIKey struct {
+	AccessKey string `json:"accessKey"`
+	SecretKey string `json:"secretKey"`
+}
+
+// LoadAPIKey reads a Rancher API key from a file holding either
+// ACCESS_KEY:SECRET_KEY or a YAML or JSON document with accessKey and
+// secretKey fields.
+func LoadAPIKey(path string) (*APIKey, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var apiKey APIKey
+	if yaml.Unmarshal(data, &apiKey) == nil && apiKey.AccessKey != "" && apiKey.SecretKey != "" {
+		return &apiKey, nil
+	}
+
+	parts := strings.SplitN(strings.TrimSpace(string(data)), ":", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("API key file %s holds neither ACCESS_KEY:SECRET_KEY nor accessKey and secretKey fields", path)
+	}
+	return &APIKey{
+		AccessKey: parts[0],
+		SecretKey: parts[1],
+	}, nil
+}
+
+// New returns an ExecCredential for the token, in the API version kubectl
+// asked for through KUBERNETES_EXEC_INFO. A zero expiry never expires.
+func New(token string, expiry time.Time) *ExecCredential {
+	apiVersion := APIVersion
+	var execInfo struct {
+		APIVersion string `json:"apiVersion"`
+	}
+	if json.Unmarshal([]byte(os.Getenv(execInfoEnv)), &execInfo) == nil && execInfo.APIVersion != "" {
+		apiVersion = execInfo.APIVersion
+	}
+
+	execCredential := &ExecCredential{
+		APIVersion: apiVersion,
+		Kind:       Kind,
+		Status: ExecCredentialStatus{
+			Token: token,
+		},
+	}
+	if !expiry.IsZero() {
+		execCredential.Status.ExpirationTimestamp = expiry.UTC().Format(time.RFC3339)
+	}
+	return execCredential
+}
+
+// Cache stores the expiry of issued credentials in a directory, one file
+// per key, so that a credential keeps its expiry across invocations. Tokens
+// are derived from the API key and never stored, and keys are only stored
+// hashed.
+type Cache struct {
+	dir string
+}
+
+type cacheEntry struct {
+	ExpirationTimestamp string `json:"expirationTimestamp"`
+}
+
+func NewCache(dir string) *Cache {
+	return &Cache{
+		dir: dir,
+	}
+}
+
+// Get returns the cached expiry for the key if it has not passed.
+func (c *Cache) Get(key string) (time.Time, bool) {
+	data, err := ioutil.ReadFile(c.path(key))
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return time.Time{}, false
+	}
+
+	expiry, err := time.Parse(time.RFC3339, entry.ExpirationTimestamp)
+	if err != nil || !time.Now().Before(expiry) {
+		return time.Time{}, false
+	}
+
+	return expiry, true
+}
+
+func (c *Cache) Put(key string, expiry time.Time) error {
+	if err := os.MkdirAll(c.dir, 0700); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(cacheEntry{
+		ExpirationTimestamp: expiry.UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(c.dir, ".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(key))
+}
+
+func (c *Cache) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:])+".json")
+}
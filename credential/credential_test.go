@@ -0,0 +1,89 @@
+package credential
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCache(t *testing.T) {
+	dir, err := ioutil.TempDir("", "credential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	cache := NewCache(filepath.Join(dir, "cache"))
+
+	if _, ok := cache.Get("access"); ok {
+		t.Error("empty cache returned an expiry")
+	}
+
+	expiry := time.Now().Add(time.Hour).Truncate(time.Second)
+	if err := cache.Put("access", expiry); err != nil {
+		t.Fatal(err)
+	}
+	if cached, ok := cache.Get("access"); !ok || !cached.Equal(expiry) {
+		t.Errorf("got expiry %v, expected %v", cached, expiry)
+	}
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(filepath.Join(dir, "cache", file.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Contains(file.Name()+string(data), "access") || strings.Contains(string(data), "token") {
+			t.Errorf("cache file %s holds more than the expiry: %s", file.Name(), data)
+		}
+	}
+
+	if err := cache.Put("expired", time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := cache.Get("expired"); ok {
+		t.Error("expired entry returned")
+	}
+}
+
+func TestLoadAPIKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		data  string
+		valid bool
+	}{
+		{"colon separated", "access:secret\n", true},
+		{"yaml", "accessKey: access\nsecretKey: secret\n", true},
+		{"json", `{"accessKey":"access","secretKey":"secret"}`, true},
+		{"no secret", "access:", false},
+		{"no separator", "access", false},
+	}
+
+	dir, err := ioutil.TempDir("", "credential")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for _, test := range tests {
+		path := filepath.Join(dir, "key")
+		if err := ioutil.WriteFile(path, []byte(test.data), 0600); err != nil {
+			t.Fatal(err)
+		}
+		apiKey, err := LoadAPIKey(path)
+		if !test.valid {
+			if err == nil {
+				t.Errorf("%s: loaded %+v", test.name, apiKey)
+			}
+			continue
+		}
+		if err != nil || apiKey.AccessKey != "access" || apiKey.SecretKey != "secret" {
+			t.Errorf("%s: got %+v, %v", test.name, apiKey, err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package filewatch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+)
+
+// Watch calls reload whenever the file, or any file directly inside the
+// directory, at path changes, and whenever the process receives SIGHUP.
+// Changes are detected by polling every interval. It never returns.
+func Watch(path string, interval time.Duration, reload func()) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	var ticks <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		ticks = ticker.C
+	}
+
+	last := state(path)
+	for {
+		select {
+		case <-hup:
+		case <-ticks:
+			current := state(path)
+			if current == last {
+				continue
+			}
+		}
+		last = state(path)
+		reload()
+	}
+}
+
+// state summarises the names, sizes and modification times of the file or
+// directory so that any change to them changes the summary.
+func state(path string) string {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err.Error()
+	}
+	if !info.IsDir() {
+		return fileState(info)
+	}
+
+	infos, err := ioutil.ReadDir(path)
+	if err != nil {
+		return err.Error()
+	}
+	s := fileState(info)
+	for _, info := range infos {
+		// Follow symlinks, as used by Kubernetes secret and configmap volumes
+		if target, err := os.Stat(filepath.Join(path, info.Name())); err == nil {
+			info = target
+		}
+		s += "|" + info.Name() + ":" + fileState(info)
+	}
+	return s
+}
+
+func fileState(info os.FileInfo) string {
+	return fmt.Sprintf("%d:%d", info.Size(), info.ModTime().UnixNano())
+}
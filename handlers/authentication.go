@@ -1,11 +1,13 @@
 package handlers
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
 
 	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
 
@@ -14,82 +16,210 @@ import (
 )
 
 const (
-	APIVersion = "authentication.k8s.io/v1beta1"
-	Kind       = "TokenReview"
+	APIVersion   = "authentication.k8s.io/v1beta1"
+	APIVersionV1 = "authentication.k8s.io/v1"
+	Kind         = "TokenReview"
 )
 
-var (
-	userInfo = map[string]k8sAuthentication.UserInfo{
-		"test": k8sAuthentication.UserInfo{
-			Username: "test",
-		},
-	}
-	unauthenticatedResponse = map[string]interface{}{
-		"apiVersion": APIVersion,
-		"kind":       "TokenReview",
-		"status": map[string]interface{}{
-			"authenticated": false,
-		},
-	}
-)
+type tokenReview struct {
+	APIVersion string            `json:"apiVersion"`
+	Kind       string            `json:"kind"`
+	Spec       *tokenReviewSpec  `json:"spec,omitempty"`
+	Status     tokenReviewStatus `json:"status"`
+}
+
+type tokenReviewSpec struct {
+	Token     string   `json:"token"`
+	Audiences []string `json:"audiences,omitempty"`
+}
+
+type tokenReviewStatus struct {
+	Authenticated bool             `json:"authenticated"`
+	User          *tokenReviewUser `json:"user,omitempty"`
+	Audiences     []string         `json:"audiences,omitempty"`
+	Error         string           `json:"error,omitempty"`
+}
+
+type tokenReviewUser struct {
+	Username string              `json:"username"`
+	UID      string              `json:"uid,omitempty"`
+	Groups   []string            `json:"groups"`
+	Extra    map[string][]string `json:"extra,omitempty"`
+}
 
-func Authentication(provider authentication.Provider) func(w http.ResponseWriter, r *http.Request) {
+// Authentication serves TokenReview requests in both the v1beta1 and v1
+// versions of authentication.k8s.io, answering in the version it was asked
+// in. Tokens are accepted for the given audiences, or for any audience the
+// apiserver asks about when none are configured. Lookups are given up when
+// the apiserver disconnects or after the timeout, if there is one.
+func Authentication(provider authentication.Provider, audiences []string, timeout time.Duration) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
-		tokenReviewResponse, err := reviewAuthentication(provider, w, r)
+		tokenReviewRequest, err := readTokenReview(r)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
+			writeTokenReview(w, http.StatusBadRequest, &tokenReview{
+				APIVersion: responseVersion(tokenReviewRequest),
+				Kind:       Kind,
+				Status: tokenReviewStatus{
+					Error: err.Error(),
+				},
+			})
 			return
 		}
 
-		response, err := json.Marshal(tokenReviewResponse)
+		ctx := r.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		status, err := reviewAuthentication(ctx, provider, audiences, tokenReviewRequest.Spec)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			w.Write([]byte(err.Error()))
-			return
+			status = errorStatus(err)
 		}
-		log.Debugf("Authentication response: %s", string(response))
-		w.Write(response)
+
+		writeTokenReview(w, http.StatusOK, &tokenReview{
+			APIVersion: tokenReviewRequest.APIVersion,
+			Kind:       Kind,
+			Status:     *status,
+		})
 	}
 }
 
-func reviewAuthentication(provider authentication.Provider, w http.ResponseWriter, r *http.Request) (map[string]interface{}, error) {
+// readTokenReview reads the TokenReview in the request body. On failure it
+// still returns as much of the request as could be read, so that the error
+// can be answered in the version it was asked in.
+func readTokenReview(r *http.Request) (*tokenReview, error) {
+	var tokenReviewRequest tokenReview
+
 	body, err := ioutil.ReadAll(r.Body)
 	if err != nil {
-		return nil, err
+		return &tokenReviewRequest, err
 	}
 	defer r.Body.Close()
 
 	log.Debugf("Authentication request: %s", string(body))
 
-	var tokenReviewRequest k8sAuthentication.TokenReview
 	if err = json.Unmarshal(body, &tokenReviewRequest); err != nil {
-		return nil, err
+		var typeMeta struct {
+			APIVersion string `json:"apiVersion"`
+		}
+		json.Unmarshal(body, &typeMeta)
+		tokenReviewRequest.APIVersion = typeMeta.APIVersion
+		return &tokenReviewRequest, fmt.Errorf("Invalid TokenReview: %v", err)
+	}
+
+	if tokenReviewRequest.APIVersion != APIVersion && tokenReviewRequest.APIVersion != APIVersionV1 {
+		return &tokenReviewRequest, fmt.Errorf("Unsupported API version %s, expected %s or %s", tokenReviewRequest.APIVersion, APIVersionV1, APIVersion)
+	}
+	if tokenReviewRequest.Spec == nil {
+		tokenReviewRequest.Spec = &tokenReviewSpec{}
+	}
+
+	return &tokenReviewRequest, nil
+}
+
+// responseVersion returns the version to answer a request in, the version
+// of the request when it is one that is served and v1beta1 otherwise.
+func responseVersion(request *tokenReview) string {
+	if request != nil && request.APIVersion == APIVersionV1 {
+		return APIVersionV1
+	}
+	return APIVersion
+}
+
+func writeTokenReview(w http.ResponseWriter, statusCode int, review *tokenReview) {
+	response, err := json.Marshal(review)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
 	}
+	log.Debugf("Authentication response: %s", string(response))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(response)
+}
 
-	if tokenReviewRequest.APIVersion != APIVersion {
-		return nil, fmt.Errorf("Unsupported API version %s", tokenReviewRequest.APIVersion)
+func reviewAuthentication(ctx context.Context, provider authentication.Provider, audiences []string, spec *tokenReviewSpec) (*tokenReviewStatus, error) {
+	var reviewAudiences []string
+	if len(spec.Audiences) > 0 {
+		reviewAudiences = intersectAudiences(spec.Audiences, audiences)
+		if len(reviewAudiences) == 0 {
+			log.Debugf("None of the requested audiences %v are accepted", spec.Audiences)
+			return &tokenReviewStatus{}, nil
+		}
 	}
 
-	token := strings.TrimSpace(tokenReviewRequest.Spec.Token)
+	token := strings.TrimSpace(spec.Token)
 
-	userInfo, err := provider.Lookup(token)
+	userInfo, err := authentication.LookupContext(ctx, provider, token)
 	if err != nil {
 		return nil, err
 	}
 	if userInfo == nil {
-		return unauthenticatedResponse, nil
+		return &tokenReviewStatus{}, nil
 	}
 
-	return map[string]interface{}{
-		"apiVersion": APIVersion,
-		"kind":       "TokenReview",
-		"status": map[string]interface{}{
-			"authenticated": true,
-			"user": map[string]interface{}{
-				"username": userInfo.Username,
-				"groups":   userInfo.Groups,
-			},
+	return &tokenReviewStatus{
+		Authenticated: true,
+		User: &tokenReviewUser{
+			Username: userInfo.Username,
+			UID:      userInfo.UID,
+			Groups:   userInfo.Groups,
+			Extra:    extra(userInfo.Extra),
 		},
+		Audiences: reviewAudiences,
 	}, nil
 }
+
+func extra(userExtra map[string]k8sAuthentication.ExtraValue) map[string][]string {
+	if len(userExtra) == 0 {
+		return nil
+	}
+	extra := map[string][]string{}
+	for key, value := range userExtra {
+		extra[key] = []string(value)
+	}
+	return extra
+}
+
+// errorStatus turns a provider failure into an unauthenticated status. The
+// error detail is only logged, the apiserver is told what kind of failure
+// occurred without any backend URLs or response bodies.
+func errorStatus(err error) *tokenReviewStatus {
+	switch {
+	case authentication.IsInvalidToken(err):
+		log.Debugf("Rejected token: %v", err)
+		return &tokenReviewStatus{}
+	case authentication.IsUnavailable(err):
+		log.Errorf("Failed to review token: %v", err)
+		return &tokenReviewStatus{
+			Error: "authentication backend unavailable",
+		}
+	default:
+		log.Errorf("Failed to review token: %v", err)
+		return &tokenReviewStatus{
+			Error: "internal error reviewing token",
+		}
+	}
+}
+
+// intersectAudiences returns the requested audiences that are accepted. When
+// no audiences are configured every requested audience is accepted.
+func intersectAudiences(requested, accepted []string) []string {
+	if len(accepted) == 0 {
+		return requested
+	}
+
+	var audiences []string
+	for _, audience := range requested {
+		for _, acceptedAudience := range accepted {
+			if audience == acceptedAudience {
+				audiences = append(audiences, audience)
+				break
+			}
+		}
+	}
+	return audiences
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	testauthentication "github.com/rancher/kubernetes-auth/authentication/test"
+)
+
+func TestAuthenticationBadRequestVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		version string
+	}{
+		{"v1 with invalid spec", `{"apiVersion":"authentication.k8s.io/v1","kind":"TokenReview","spec":{"token":1}}`, APIVersionV1},
+		{"v1beta1 with invalid spec", `{"apiVersion":"authentication.k8s.io/v1beta1","kind":"TokenReview","spec":{"token":1}}`, APIVersion},
+		{"unsupported version", `{"apiVersion":"authentication.k8s.io/v2","kind":"TokenReview"}`, APIVersion},
+		{"not json", `token`, APIVersion},
+	}
+
+	handler := Authentication(&testauthentication.Provider{}, nil, 0)
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/v1/authenticate", strings.NewReader(test.body)))
+
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, expected %d", test.name, recorder.Code, http.StatusBadRequest)
+		}
+		var review tokenReview
+		if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if review.APIVersion != test.version {
+			t.Errorf("%s: answered in %s, expected %s", test.name, review.APIVersion, test.version)
+		}
+		if review.Status.Error == "" {
+			t.Errorf("%s: no error in status", test.name)
+		}
+	}
+}
+
+func TestAuthenticationVersion(t *testing.T) {
+	handler := Authentication(&testauthentication.Provider{}, nil, 0)
+	for _, version := range []string{APIVersion, APIVersionV1} {
+		body := `{"apiVersion":"` + version + `","kind":"TokenReview","spec":{"token":"test1"}}`
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/v1/authenticate", strings.NewReader(body)))
+
+		var review tokenReview
+		if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+			t.Fatalf("%s: %v", version, err)
+		}
+		if review.APIVersion != version {
+			t.Errorf("answered %s in %s", version, review.APIVersion)
+		}
+		if !review.Status.Authenticated || review.Status.User.Username != "test1" {
+			t.Errorf("%s: test1 not authenticated: %+v", version, review.Status)
+		}
+	}
+}
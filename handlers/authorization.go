@@ -0,0 +1,178 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/kubernetes-auth/authorization"
+)
+
+const (
+	AuthorizationAPIVersion   = "authorization.k8s.io/v1beta1"
+	AuthorizationAPIVersionV1 = "authorization.k8s.io/v1"
+	AuthorizationKind         = "SubjectAccessReview"
+)
+
+type subjectAccessReview struct {
+	APIVersion string                    `json:"apiVersion"`
+	Kind       string                    `json:"kind"`
+	Spec       *subjectAccessReviewSpec  `json:"spec,omitempty"`
+	Status     subjectAccessReviewStatus `json:"status"`
+}
+
+type subjectAccessReviewSpec struct {
+	ResourceAttributes    *resourceAttributes    `json:"resourceAttributes,omitempty"`
+	NonResourceAttributes *nonResourceAttributes `json:"nonResourceAttributes,omitempty"`
+	User                  string                 `json:"user,omitempty"`
+	// Group is the v1beta1 name of Groups
+	Group  []string            `json:"group,omitempty"`
+	Groups []string            `json:"groups,omitempty"`
+	Extra  map[string][]string `json:"extra,omitempty"`
+	UID    string              `json:"uid,omitempty"`
+}
+
+type resourceAttributes struct {
+	Namespace   string `json:"namespace,omitempty"`
+	Verb        string `json:"verb,omitempty"`
+	Group       string `json:"group,omitempty"`
+	Version     string `json:"version,omitempty"`
+	Resource    string `json:"resource,omitempty"`
+	Subresource string `json:"subresource,omitempty"`
+	Name        string `json:"name,omitempty"`
+}
+
+type nonResourceAttributes struct {
+	Path string `json:"path,omitempty"`
+	Verb string `json:"verb,omitempty"`
+}
+
+type subjectAccessReviewStatus struct {
+	Allowed         bool   `json:"allowed"`
+	Reason          string `json:"reason,omitempty"`
+	EvaluationError string `json:"evaluationError,omitempty"`
+}
+
+// Authorization serves SubjectAccessReview requests in both the v1beta1 and
+// v1 versions of authorization.k8s.io. Reviews are given up when the
+// apiserver disconnects or after the timeout, if there is one.
+func Authorization(authorizer authorization.Authorizer, timeout time.Duration) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		review, err := readSubjectAccessReview(r)
+		if err != nil {
+			writeSubjectAccessReview(w, http.StatusBadRequest, &subjectAccessReview{
+				APIVersion: authorizationResponseVersion(review),
+				Kind:       AuthorizationKind,
+				Status: subjectAccessReviewStatus{
+					EvaluationError: err.Error(),
+				},
+			})
+			return
+		}
+
+		ctx := r.Context()
+		if timeout > 0 {
+			var cancel context.CancelFunc
+			ctx, cancel = context.WithTimeout(ctx, timeout)
+			defer cancel()
+		}
+
+		status := subjectAccessReviewStatus{}
+		status.Allowed, status.Reason, err = authorizer.Authorize(ctx, getAttributes(review.Spec))
+		if err != nil {
+			log.Errorf("Failed to authorize request: %v", err)
+			status = subjectAccessReviewStatus{
+				EvaluationError: "authorization backend unavailable",
+			}
+		}
+
+		writeSubjectAccessReview(w, http.StatusOK, &subjectAccessReview{
+			APIVersion: review.APIVersion,
+			Kind:       AuthorizationKind,
+			Status:     status,
+		})
+	}
+}
+
+// readSubjectAccessReview returns the review along with any error, as much
+// of it as could be read, so that errors can be answered in its version.
+func readSubjectAccessReview(r *http.Request) (*subjectAccessReview, error) {
+	var review subjectAccessReview
+
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return &review, err
+	}
+	defer r.Body.Close()
+
+	log.Debugf("Authorization request: %s", string(body))
+
+	if err = json.Unmarshal(body, &review); err != nil {
+		var typeMeta struct {
+			APIVersion string `json:"apiVersion"`
+		}
+		json.Unmarshal(body, &typeMeta)
+		review.APIVersion = typeMeta.APIVersion
+		return &review, fmt.Errorf("Invalid SubjectAccessReview: %v", err)
+	}
+
+	if review.APIVersion != AuthorizationAPIVersion && review.APIVersion != AuthorizationAPIVersionV1 {
+		return &review, fmt.Errorf("Unsupported API version %s, expected %s or %s", review.APIVersion, AuthorizationAPIVersionV1, AuthorizationAPIVersion)
+	}
+	if review.Spec == nil {
+		return &review, fmt.Errorf("Invalid SubjectAccessReview: missing spec")
+	}
+
+	return &review, nil
+}
+
+// authorizationResponseVersion returns the version to answer a review in,
+// the version of the review when it is one that is served and v1beta1
+// otherwise.
+func authorizationResponseVersion(review *subjectAccessReview) string {
+	if review != nil && review.APIVersion == AuthorizationAPIVersionV1 {
+		return AuthorizationAPIVersionV1
+	}
+	return AuthorizationAPIVersion
+}
+
+func writeSubjectAccessReview(w http.ResponseWriter, statusCode int, review *subjectAccessReview) {
+	response, err := json.Marshal(review)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	log.Debugf("Authorization response: %s", string(response))
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(response)
+}
+
+func getAttributes(spec *subjectAccessReviewSpec) authorization.Attributes {
+	attributes := authorization.Attributes{
+		User:   spec.User,
+		UID:    spec.UID,
+		Groups: append(spec.Groups, spec.Group...),
+		Extra:  spec.Extra,
+	}
+
+	if spec.ResourceAttributes != nil {
+		attributes.ResourceRequest = true
+		attributes.Verb = spec.ResourceAttributes.Verb
+		attributes.Namespace = spec.ResourceAttributes.Namespace
+		attributes.APIGroup = spec.ResourceAttributes.Group
+		attributes.Resource = spec.ResourceAttributes.Resource
+		attributes.Subresource = spec.ResourceAttributes.Subresource
+		attributes.Name = spec.ResourceAttributes.Name
+	} else if spec.NonResourceAttributes != nil {
+		attributes.Verb = spec.NonResourceAttributes.Verb
+		attributes.Path = spec.NonResourceAttributes.Path
+	}
+
+	return attributes
+}
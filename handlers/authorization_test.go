@@ -0,0 +1,72 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/rancher/kubernetes-auth/authorization"
+)
+
+type allowAuthorizer struct{}
+
+func (allowAuthorizer) Authorize(ctx context.Context, attributes authorization.Attributes) (bool, string, error) {
+	return attributes.User == "allowed", "", nil
+}
+
+func TestAuthorizationBadRequestVersion(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		version string
+	}{
+		{"v1 with invalid spec", `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview","spec":{"user":1}}`, AuthorizationAPIVersionV1},
+		{"v1 without spec", `{"apiVersion":"authorization.k8s.io/v1","kind":"SubjectAccessReview"}`, AuthorizationAPIVersionV1},
+		{"v1beta1 with invalid spec", `{"apiVersion":"authorization.k8s.io/v1beta1","kind":"SubjectAccessReview","spec":{"user":1}}`, AuthorizationAPIVersion},
+		{"unsupported version", `{"apiVersion":"authorization.k8s.io/v2","kind":"SubjectAccessReview"}`, AuthorizationAPIVersion},
+		{"not json", `review`, AuthorizationAPIVersion},
+	}
+
+	handler := Authorization(allowAuthorizer{}, 0)
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/v1/authorize", strings.NewReader(test.body)))
+
+		if recorder.Code != http.StatusBadRequest {
+			t.Errorf("%s: status %d, expected %d", test.name, recorder.Code, http.StatusBadRequest)
+		}
+		var review subjectAccessReview
+		if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if review.APIVersion != test.version {
+			t.Errorf("%s: answered in %s, expected %s", test.name, review.APIVersion, test.version)
+		}
+		if review.Status.EvaluationError == "" {
+			t.Errorf("%s: no evaluation error in status", test.name)
+		}
+	}
+}
+
+func TestAuthorizationVersion(t *testing.T) {
+	handler := Authorization(allowAuthorizer{}, 0)
+	for _, version := range []string{AuthorizationAPIVersion, AuthorizationAPIVersionV1} {
+		body := `{"apiVersion":"` + version + `","kind":"SubjectAccessReview","spec":{"user":"allowed"}}`
+		recorder := httptest.NewRecorder()
+		handler(recorder, httptest.NewRequest(http.MethodPost, "/v1/authorize", strings.NewReader(body)))
+
+		var review subjectAccessReview
+		if err := json.Unmarshal(recorder.Body.Bytes(), &review); err != nil {
+			t.Fatalf("%s: %v", version, err)
+		}
+		if review.APIVersion != version {
+			t.Errorf("answered %s in %s", version, review.APIVersion)
+		}
+		if !review.Status.Allowed {
+			t.Errorf("%s: request not allowed: %+v", version, review.Status)
+		}
+	}
+}
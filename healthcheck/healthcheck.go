@@ -3,17 +3,41 @@ package healthcheck
 import (
 	"fmt"
 	"net/http"
+	"sort"
 	"strconv"
+	"sync"
 
 	log "github.com/Sirupsen/logrus"
 )
 
+// Check reports why a component is unhealthy, or nil if it is healthy.
+type Check func() error
+
+var (
+	checksLock sync.Mutex
+	checks     = map[string]Check{}
+)
+
+// Register adds a check that must pass for the health check to report ok.
+func Register(name string, check Check) {
+	checksLock.Lock()
+	defer checksLock.Unlock()
+	checks[name] = check
+}
+
 func Start(port int) error {
 	if port <= 0 || port > 65535 {
 		return fmt.Errorf("Invalid health check port number: %v", port)
 	}
 
 	http.HandleFunc("/healthcheck", func(w http.ResponseWriter, r *http.Request) {
+		if failures := failedChecks(); len(failures) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			for _, failure := range failures {
+				fmt.Fprintln(w, failure)
+			}
+			return
+		}
 		fmt.Fprint(w, "ok")
 	})
 
@@ -21,3 +45,17 @@ func Start(port int) error {
 	log.Infof("Listening for health checks on 0.0.0.0%s/healthcheck", p)
 	return http.ListenAndServe(p, nil)
 }
+
+func failedChecks() []string {
+	checksLock.Lock()
+	defer checksLock.Unlock()
+
+	var failures []string
+	for name, check := range checks {
+		if err := check(); err != nil {
+			failures = append(failures, fmt.Sprintf("%s: %v", name, err))
+		}
+	}
+	sort.Strings(failures)
+	return failures
+}
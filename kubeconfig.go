@@ -0,0 +1,260 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/go-rancher/v2"
+	"github.com/rancher/kubernetes-auth/authentication/rancher"
+	"github.com/rancher/kubernetes-auth/credential"
+	"github.com/rancher/kubernetes-auth/kubeconfig"
+	"github.com/urfave/cli"
+)
+
+func kubeconfigCommand() cli.Command {
+	return cli.Command{
+		Name:   "kubeconfig",
+		Usage:  "Create or reuse a Rancher API key and print a kubeconfig using it",
+		Action: runKubeconfig,
+		Flags: []cli.Flag{
+			cli.StringFlag{
+				Name:   "rancher-url",
+				Usage:  "URL of the Rancher API",
+				EnvVar: "CATTLE_URL",
+			},
+			cli.StringFlag{
+				Name:   "access-key",
+				Usage:  "Rancher API access key of the user",
+				EnvVar: "RANCHER_ACCESS_KEY",
+			},
+			cli.StringFlag{
+				Name:   "secret-key",
+				Usage:  "Rancher API secret key of the user",
+				EnvVar: "RANCHER_SECRET_KEY",
+			},
+			cli.BoolFlag{
+				Name:  "reuse",
+				Usage: "Embed the API key given with --access-key and --secret-key instead of creating a new one",
+			},
+			cli.StringFlag{
+				Name:  "api-key-name",
+				Value: "kubectl",
+				Usage: "Name of the API key to create",
+			},
+			cli.BoolFlag{
+				Name:  "replace",
+				Usage: "Remove API keys of the same name created by an earlier run once the new key exists, instead of refusing to create another",
+			},
+			cli.StringFlag{
+				Name:  "account-id",
+				Usage: "Account to create the API key for, defaults to the account of the user",
+			},
+			cli.StringFlag{
+				Name:   "server",
+				Value:  "https://kubernetes:6443",
+				Usage:  "URL of the apiserver",
+				EnvVar: "KUBERNETES_URL",
+			},
+			cli.StringFlag{
+				Name:  "ca-file",
+				Value: "/etc/kubernetes/ssl/ca.pem",
+				Usage: "CA of the apiserver to embed",
+			},
+			cli.BoolFlag{
+				Name:  "insecure-skip-tls-verify",
+				Usage: "Do not verify the apiserver certificate instead of embedding a CA",
+			},
+			cli.StringFlag{
+				Name:  "name",
+				Value: "kubernetes",
+				Usage: "Name of the cluster, user and context",
+			},
+			cli.BoolFlag{
+				Name:  "exec",
+				Usage: "Use the credential subcommand as an exec plugin instead of a static token",
+			},
+			cli.StringFlag{
+				Name:  "exec-command",
+				Value: "kubernetes-auth",
+				Usage: "Path to this binary on the machine kubectl runs on",
+			},
+			cli.StringFlag{
+				Name:  "output,o",
+				Usage: "File to write the kubeconfig to, defaults to stdout",
+			},
+		},
+	}
+}
+
+func runKubeconfig(c *cli.Context) error {
+	apiKey, err := kubeconfigAPIKey(c)
+	if err != nil {
+		return err
+	}
+
+	cluster := kubeconfig.Cluster{
+		Server:                c.String("server"),
+		InsecureSkipTLSVerify: c.Bool("insecure-skip-tls-verify"),
+	}
+	if !cluster.InsecureSkipTLSVerify {
+		if cluster.CertificateAuthorityData, err = ioutil.ReadFile(c.String("ca-file")); err != nil {
+			return fmt.Errorf("Failed to read apiserver CA, use --ca-file or --insecure-skip-tls-verify: %v", err)
+		}
+	}
+
+	var user kubeconfig.User
+	if c.Bool("exec") {
+		user.Exec = &kubeconfig.ExecConfig{
+			APIVersion: credential.APIVersion,
+			Command:    c.String("exec-command"),
+			Args:       []string{"credential"},
+			Env: []kubeconfig.EnvVar{
+				{Name: "RANCHER_ACCESS_KEY", Value: apiKey.AccessKey},
+				{Name: "RANCHER_SECRET_KEY", Value: apiKey.SecretKey},
+			},
+		}
+	} else {
+		user.Token = rancherauthentication.EncodeToken(apiKey.AccessKey, apiKey.SecretKey)
+	}
+
+	data, err := kubeconfig.New(c.String("name"), cluster, user).Marshal()
+	if err != nil {
+		return err
+	}
+
+	output := c.String("output")
+	if output == "" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	if err := ioutil.WriteFile(output, data, 0600); err != nil {
+		return err
+	}
+	log.Infof("Wrote kubeconfig to %s", output)
+	return nil
+}
+
+// apiKeyDescription marks the API keys created by the kubeconfig command
+const apiKeyDescription = "Kubernetes access created by kubernetes-auth"
+
+// existingAPIKeys returns the keys of the given name, created by the
+// kubeconfig command and not yet removed, for the account or else the
+// account of the caller.
+func existingAPIKeys(rancherClient *client.RancherClient, name, accountID string) ([]client.ApiKey, error) {
+	filters := map[string]interface{}{
+		"name":        name,
+		"description": apiKeyDescription,
+	}
+	if accountID != "" {
+		filters["accountId"] = accountID
+	}
+	apiKeys, err := rancherClient.ApiKey.List(&client.ListOpts{
+		Filters: filters,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var existing []client.ApiKey
+	for apiKeys != nil {
+		for _, apiKey := range apiKeys.Data {
+			if apiKey.Name == name && apiKey.Description == apiKeyDescription && apiKey.Removed == "" &&
+				(accountID == "" || apiKey.AccountId == accountID) {
+				existing = append(existing, apiKey)
+			}
+		}
+		if apiKeys, err = apiKeys.Next(); err != nil {
+			return nil, err
+		}
+	}
+	return existing, nil
+}
+
+// removeAPIKey deactivates the key if needed and removes it.
+func removeAPIKey(rancherClient *client.RancherClient, apiKey client.ApiKey) error {
+	if apiKey.State == rancherauthentication.ActiveState {
+		if _, err := rancherClient.ApiKey.ActionDeactivate(&apiKey); err != nil {
+			return err
+		}
+	}
+	return rancherClient.ApiKey.Delete(&apiKey)
+}
+
+// kubeconfigAPIKey returns the API key to embed, either the one the user
+// authenticated with or a new one created through the Rancher API.
+func kubeconfigAPIKey(c *cli.Context) (*credential.APIKey, error) {
+	accessKey, secretKey := c.String("access-key"), c.String("secret-key")
+	if accessKey == "" || secretKey == "" {
+		return nil, fmt.Errorf("A Rancher API key is required, use --access-key and --secret-key")
+	}
+
+	url, err := client.NormalizeUrl(c.String("rancher-url"))
+	if err != nil {
+		return nil, err
+	}
+	rancherClient, err := client.NewRancherClient(&client.ClientOpts{
+		Url:       url,
+		AccessKey: accessKey,
+		SecretKey: secretKey,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Bool("reuse") {
+		apiKeys, err := rancherClient.ApiKey.List(&client.ListOpts{
+			Filters: map[string]interface{}{
+				"publicValue": accessKey,
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		for _, apiKey := range apiKeys.Data {
+			if apiKey.PublicValue == accessKey && apiKey.State == rancherauthentication.ActiveState {
+				log.Infof("Reusing API key %s", apiKey.Id)
+				return &credential.APIKey{
+					AccessKey: accessKey,
+					SecretKey: secretKey,
+				}, nil
+			}
+		}
+		return nil, fmt.Errorf("API key %s is not an active account API key and cannot be reused", accessKey)
+	}
+
+	name := c.String("api-key-name")
+	existing, err := existingAPIKeys(rancherClient, name, c.String("account-id"))
+	if err != nil {
+		return nil, err
+	}
+	if len(existing) > 0 && !c.Bool("replace") {
+		return nil, fmt.Errorf("API key %s named %s was already created by kubernetes-auth, reuse it with --reuse or replace it with --replace", existing[0].PublicValue, name)
+	}
+
+	apiKey, err := rancherClient.ApiKey.Create(&client.ApiKey{
+		Name:        name,
+		Description: apiKeyDescription,
+		AccountId:   c.String("account-id"),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if apiKey.SecretValue == "" {
+		return nil, fmt.Errorf("Rancher did not return the secret of API key %s", apiKey.Id)
+	}
+	log.Infof("Created API key %s", apiKey.Id)
+
+	for _, old := range existing {
+		if err := removeAPIKey(rancherClient, old); err != nil {
+			return nil, fmt.Errorf("Failed to remove replaced API key %s: %v", old.Id, err)
+		}
+		log.Infof("Removed replaced API key %s", old.Id)
+	}
+
+	return &credential.APIKey{
+		AccessKey: apiKey.PublicValue,
+		SecretKey: apiKey.SecretValue,
+	}, nil
+}
@@ -0,0 +1,80 @@
+package kubeconfig
+
+import (
+	"github.com/ghodss/yaml"
+)
+
+type Config struct {
+	APIVersion     string         `json:"apiVersion"`
+	Kind           string         `json:"kind"`
+	Clusters       []NamedCluster `json:"clusters"`
+	Users          []NamedUser    `json:"users"`
+	Contexts       []NamedContext `json:"contexts"`
+	CurrentContext string         `json:"current-context"`
+}
+
+type NamedCluster struct {
+	Name    string  `json:"name"`
+	Cluster Cluster `json:"cluster"`
+}
+
+type Cluster struct {
+	Server                   string `json:"server"`
+	CertificateAuthorityData []byte `json:"certificate-authority-data,omitempty"`
+	InsecureSkipTLSVerify    bool   `json:"insecure-skip-tls-verify,omitempty"`
+}
+
+type NamedUser struct {
+	Name string `json:"name"`
+	User User   `json:"user"`
+}
+
+type User struct {
+	Token string      `json:"token,omitempty"`
+	Exec  *ExecConfig `json:"exec,omitempty"`
+}
+
+type ExecConfig struct {
+	APIVersion string   `json:"apiVersion"`
+	Command    string   `json:"command"`
+	Args       []string `json:"args,omitempty"`
+	Env        []EnvVar `json:"env,omitempty"`
+}
+
+type EnvVar struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type NamedContext struct {
+	Name    string  `json:"name"`
+	Context Context `json:"context"`
+}
+
+type Context struct {
+	Cluster string `json:"cluster"`
+	User    string `json:"user"`
+}
+
+// New returns a kubeconfig with a single cluster, user and context, all
+// named after the cluster.
+func New(name string, cluster Cluster, user User) *Config {
+	return &Config{
+		APIVersion: "v1",
+		Kind:       "Config",
+		Clusters: []NamedCluster{
+			{Name: name, Cluster: cluster},
+		},
+		Users: []NamedUser{
+			{Name: name, User: user},
+		},
+		Contexts: []NamedContext{
+			{Name: name, Context: Context{Cluster: name, User: name}},
+		},
+		CurrentContext: name,
+	}
+}
+
+func (c *Config) Marshal() ([]byte, error) {
+	return yaml.Marshal(c)
+}
@@ -2,17 +2,17 @@ package main
 
 import (
 	"fmt"
-	"io/ioutil"
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"github.com/rancher/kubernetes-auth/authentication"
-	"github.com/rancher/kubernetes-auth/authentication/rancher"
-	"github.com/rancher/kubernetes-auth/authentication/test"
+	"github.com/rancher/kubernetes-auth/authentication/union"
 	"github.com/rancher/kubernetes-auth/handlers"
 	"github.com/rancher/kubernetes-auth/healthcheck"
+	"github.com/rancher/kubernetes-auth/oidc"
 	"github.com/urfave/cli"
 )
 
@@ -26,9 +26,23 @@ func main() {
 		cli.BoolFlag{
 			Name: "debug,d",
 		},
+		cli.StringSliceFlag{
+			Name:  "provider",
+			Usage: fmt.Sprintf("Authentication provider to use, configured from the other flags, repeat to try several in order: %s", strings.Join(authentication.Names(), ", ")),
+		},
+		cli.StringFlag{
+			Name:   "provider-config",
+			Usage:  "YAML or JSON file listing the authentication providers to try in order and their configuration, instead of --provider",
+			EnvVar: "PROVIDER_CONFIG",
+		},
 		cli.BoolFlag{
 			Name: "test-authentication",
 		},
+		cli.StringFlag{
+			Name:   "test-fixtures",
+			Usage:  "YAML or JSON file of users, groups, extras and token behaviours for --test-authentication",
+			EnvVar: "TEST_FIXTURES",
+		},
 		cli.StringFlag{
 			Name: "evaluate-token",
 		},
@@ -44,66 +58,204 @@ func main() {
 			Usage:  "Port to configure an HTTP health check listener on",
 			EnvVar: "HEALTH_CHECK_PORT",
 		},
+		cli.DurationFlag{
+			Name:   "lookup-timeout",
+			Value:  10 * time.Second,
+			Usage:  "Overall deadline for authenticating a token or authorizing a request, also bounding the Rancher requests shared between lookups, keep it below the apiserver's webhook timeout, 0 for no overall deadline with Rancher requests still given up after 30s",
+			EnvVar: "LOOKUP_TIMEOUT",
+		},
+		cli.StringSliceFlag{
+			Name:  "audience",
+			Usage: "Audience tokens are accepted for, defaults to any audience requested by the apiserver",
+		},
+		cli.StringFlag{
+			Name:   "authorization-policy",
+			Usage:  "YAML or JSON file mapping Rancher environment roles to allowed requests",
+			EnvVar: "AUTHORIZATION_POLICY",
+		},
+		cli.StringFlag{
+			Name:   "oidc-issuer-url",
+			Usage:  "Serve an OpenID Connect issuer exchanging Rancher tokens for ID tokens at this external https URL, which must reach the authentication webhook port through a TLS terminating proxy",
+			EnvVar: "OIDC_ISSUER_URL",
+		},
+		cli.StringFlag{
+			Name:   "oidc-client-id",
+			Value:  "kubernetes",
+			Usage:  "Audience of issued ID tokens, the apiserver's --oidc-client-id",
+			EnvVar: "OIDC_CLIENT_ID",
+		},
+		cli.DurationFlag{
+			Name:   "oidc-token-ttl",
+			Value:  10 * time.Minute,
+			Usage:  "Lifetime of issued ID tokens",
+			EnvVar: "OIDC_TOKEN_TTL",
+		},
+		cli.DurationFlag{
+			Name:   "oidc-key-rotation-interval",
+			Value:  24 * time.Hour,
+			Usage:  "How often to rotate the ID token signing key",
+			EnvVar: "OIDC_KEY_ROTATION_INTERVAL",
+		},
+		cli.StringFlag{
+			Name:   "token-file",
+			Usage:  "File of static tokens, checked before the Rancher or test provider, in kube-apiserver --token-auth-file CSV format or as YAML or JSON",
+			EnvVar: "TOKEN_FILE",
+		},
+		cli.StringFlag{
+			Name:   "bootstrap-token-file",
+			Usage:  "File, or directory of files, of bootstrap tokens checked before any other provider when the rancher provider is used, one per line or as YAML or JSON with users, groups and expiry times",
+			EnvVar: "BOOTSTRAP_TOKEN_FILE",
+		},
+		cli.StringFlag{
+			Name:   "bootstrap-key-file",
+			Usage:  "Key file whose hex encoded sha256 sum is a bootstrap token, re-derived whenever the key changes",
+			EnvVar: "BOOTSTRAP_KEY_FILE",
+		},
+		cli.DurationFlag{
+			Name:   "bootstrap-rotation-grace",
+			Value:  5 * time.Minute,
+			Usage:  "How long a bootstrap token remains valid after being removed or rotated",
+			EnvVar: "BOOTSTRAP_ROTATION_GRACE",
+		},
+		cli.StringFlag{
+			Name:   "on-provider-error",
+			Value:  string(unionauthentication.StopOnError),
+			Usage:  "Whether a provider failing to check a token stops the lookup (stop) or falls through to the next provider (continue)",
+			EnvVar: "ON_PROVIDER_ERROR",
+		},
+		cli.StringFlag{
+			Name:   "rancher-environment",
+			Usage:  "ID, name or UUID of the Rancher environment whose members are authenticated, or \"metadata\" for the environment this service runs in, defaults to the only environment visible to the API key",
+			EnvVar: "RANCHER_ENVIRONMENT",
+		},
+		cli.StringFlag{
+			Name:   "admin-group",
+			Value:  "system:masters",
+			Usage:  "Group given to Rancher admins and bootstrap tokens, and to environment owners unless --role-group maps them otherwise",
+			EnvVar: "ADMIN_GROUP",
+		},
+		cli.StringFlag{
+			Name:   "auth-disabled-policy",
+			Value:  "deny",
+			Usage:  "How tokens are treated while Rancher has access control disabled: deny, readonly to authenticate everyone in --auth-disabled-group, or admin",
+			EnvVar: "AUTH_DISABLED_POLICY",
+		},
+		cli.StringFlag{
+			Name:   "auth-disabled-group",
+			Value:  "rancher:auth-disabled",
+			Usage:  "Group everyone is authenticated in with --auth-disabled-policy readonly, bind it to a read-only ClusterRole",
+			EnvVar: "AUTH_DISABLED_GROUP",
+		},
+		cli.StringSliceFlag{
+			Name:  "role-group",
+			Usage: "Groups given to members holding a Rancher environment role, as role=group1,group2, for example readonly=rancher:role:readonly",
+		},
+		cli.StringFlag{
+			Name:   "username-source",
+			Value:  "login",
+			Usage:  "Identity attribute usernames are taken from: login, name, externalId or rancherId",
+			EnvVar: "USERNAME_SOURCE",
+		},
+		cli.StringFlag{
+			Name:   "username-prefix",
+			Usage:  "Prefix added to usernames from Rancher, for example rancher:",
+			EnvVar: "USERNAME_PREFIX",
+		},
+		cli.StringFlag{
+			Name:   "group-prefix",
+			Usage:  "Prefix added to group names from Rancher, for example rancher:",
+			EnvVar: "GROUP_PREFIX",
+		},
+		cli.IntFlag{
+			Name:   "cache-size",
+			Value:  1024,
+			Usage:  "Maximum number of authentication decisions to cache, 0 disables caching",
+			EnvVar: "CACHE_SIZE",
+		},
+		cli.DurationFlag{
+			Name:   "cache-ttl",
+			Value:  time.Minute,
+			Usage:  "How long to cache successful authentication decisions",
+			EnvVar: "CACHE_TTL",
+		},
+		cli.DurationFlag{
+			Name:   "cache-negative-ttl",
+			Value:  10 * time.Second,
+			Usage:  "How long to cache rejected tokens",
+			EnvVar: "CACHE_NEGATIVE_TTL",
+		},
 	}
-	app.Action = func(c *cli.Context) error {
+	app.Before = func(c *cli.Context) error {
 		if c.Bool("debug") {
 			log.Warn("All tokens will be logged when in debug mode")
 			log.SetLevel(log.DebugLevel)
 		}
+		return nil
+	}
+	app.Action = run
+	app.Commands = []cli.Command{
+		proxyCommand(),
+		credentialCommand(),
+		kubeconfigCommand(),
+	}
+
+	if err := app.Run(os.Args); err != nil {
+		log.Fatal(err)
+	}
+}
+
+func run(c *cli.Context) error {
+	provider, rancher, err := newProvider(c)
+	if err != nil {
+		return err
+	}
+	authorizer, err := newAuthorizer(c, rancher)
+	if err != nil {
+		return err
+	}
 
-		bytes, err := ioutil.ReadAll(os.Stdin)
+	evaluateToken := c.String("evaluate-token")
+	if evaluateToken != "" {
+		userInfo, err := provider.Lookup(evaluateToken)
 		if err != nil {
 			return err
 		}
-		bootstrapToken := strings.TrimSpace(string(bytes))
-
-		if bootstrapToken != "" {
-			log.Info("Bootstrap token read from stdin")
-			log.Debugf("Bootstrap token: %s", bootstrapToken)
+		if userInfo == nil {
+			return fmt.Errorf("Failed to evaluate token %s", evaluateToken)
 		}
+		fmt.Println("Username", userInfo.Username)
+		fmt.Println("Groups", userInfo.Groups)
+		fmt.Println("Extra", userInfo.Extra)
+		return nil
+	}
 
-		var provider authentication.Provider
-		if c.Bool("test-authentication") {
-			provider = &testauthentication.Provider{}
-		} else {
-			var err error
-			provider, err = rancherauthentication.NewProvider(bootstrapToken)
-			if err != nil {
-				return err
-			}
+	if issuerURL := c.String("oidc-issuer-url"); issuerURL != "" {
+		if rancher == nil {
+			return fmt.Errorf("The OIDC issuer needs the rancher provider, only Rancher tokens are exchanged for ID tokens")
 		}
-
-		evaluateToken := c.String("evaluate-token")
-		if evaluateToken != "" {
-			userInfo, err := provider.Lookup(evaluateToken)
-			if err != nil {
-				return err
-			}
-			if userInfo == nil {
-				return fmt.Errorf("Failed to evaluate token %s", evaluateToken)
-			}
-			fmt.Println("Username", userInfo.Username)
-			fmt.Println("Groups", userInfo.Groups)
-			return nil
+		issuer, err := oidc.NewIssuer(issuerURL, c.String("oidc-client-id"), c.Duration("oidc-token-ttl"), c.Duration("oidc-key-rotation-interval"), rancher)
+		if err != nil {
+			return err
 		}
+		go issuer.RotateKeys()
+		issuer.RegisterHandlers(http.DefaultServeMux)
+	}
 
-		resultChan := make(chan error)
-
-		go func(rc chan error) {
-			http.HandleFunc("/", handlers.Authentication(provider))
-			port := c.Int("authentication-webhook-port")
-			rc <- http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
-		}(resultChan)
+	resultChan := make(chan error)
 
-		go func(rc chan error) {
-			port := c.Int("health-check-port")
-			rc <- healthcheck.Start(port)
-		}(resultChan)
+	go func(rc chan error) {
+		http.HandleFunc("/", handlers.Authentication(provider, c.StringSlice("audience"), c.Duration("lookup-timeout")))
+		if authorizer != nil {
+			http.HandleFunc("/authorize", handlers.Authorization(authorizer, c.Duration("lookup-timeout")))
+		}
+		port := c.Int("authentication-webhook-port")
+		rc <- http.ListenAndServe(fmt.Sprintf(":%d", port), nil)
+	}(resultChan)
 
-		return <-resultChan
-	}
+	go func(rc chan error) {
+		port := c.Int("health-check-port")
+		rc <- healthcheck.Start(port)
+	}(resultChan)
 
-	if err := app.Run(os.Args); err != nil {
-		log.Fatal(err)
-	}
+	return <-resultChan
 }
@@ -0,0 +1,218 @@
+package oidc
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/kubernetes-auth/authentication"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	jwksPath      = "/openid/v1/jwks"
+	tokenPath     = "/openid/v1/token"
+)
+
+type discovery struct {
+	Issuer                           string   `json:"issuer"`
+	JWKSURI                          string   `json:"jwks_uri"`
+	TokenEndpoint                    string   `json:"token_endpoint"`
+	ResponseTypesSupported           []string `json:"response_types_supported"`
+	SubjectTypesSupported            []string `json:"subject_types_supported"`
+	IDTokenSigningAlgValuesSupported []string `json:"id_token_signing_alg_values_supported"`
+	ClaimsSupported                  []string `json:"claims_supported"`
+}
+
+type tokenResponse struct {
+	IDToken   string `json:"id_token"`
+	TokenType string `json:"token_type"`
+	ExpiresIn int64  `json:"expires_in"`
+}
+
+type errorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+// Issuer is a minimal OpenID Connect provider. It exchanges tokens accepted
+// by an authentication provider for short-lived ID tokens the apiserver can
+// verify with its --oidc-* flags, signed with keys that only ever live in
+// memory. The apiserver only accepts https issuers, so the issuer URL must
+// be https even though the handlers are served behind a TLS terminating
+// proxy.
+type Issuer struct {
+	issuer              string
+	path                string
+	clientID            string
+	tokenTTL            time.Duration
+	keyRotationInterval time.Duration
+	provider            authentication.Provider
+	keys                keySet
+}
+
+func NewIssuer(issuerURL, clientID string, tokenTTL, keyRotationInterval time.Duration, provider authentication.Provider) (*Issuer, error) {
+	u, err := url.Parse(issuerURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "https" || u.Host == "" || u.RawQuery != "" || u.Fragment != "" {
+		return nil, fmt.Errorf("Invalid OIDC issuer URL %s, expected an https URL without query or fragment", issuerURL)
+	}
+	if tokenTTL <= 0 {
+		return nil, fmt.Errorf("Invalid OIDC token TTL %v", tokenTTL)
+	}
+	if keyRotationInterval <= 0 {
+		return nil, fmt.Errorf("Invalid OIDC key rotation interval %v", keyRotationInterval)
+	}
+
+	issuer := &Issuer{
+		issuer:              strings.TrimSuffix(issuerURL, "/"),
+		path:                strings.TrimSuffix(u.Path, "/"),
+		clientID:            clientID,
+		tokenTTL:            tokenTTL,
+		keyRotationInterval: keyRotationInterval,
+		provider:            provider,
+	}
+	if err := issuer.keys.rotate(tokenTTL); err != nil {
+		return nil, err
+	}
+	return issuer, nil
+}
+
+// RotateKeys replaces the signing key every key rotation interval. Retired
+// keys stay in the published key set until every token they signed has
+// expired.
+func (i *Issuer) RotateKeys() {
+	for range time.Tick(i.keyRotationInterval) {
+		if err := i.keys.rotate(i.tokenTTL); err != nil {
+			log.Errorf("Failed to rotate OIDC signing key: %v", err)
+			continue
+		}
+		log.Infof("Rotated OIDC signing key, now signing with %s", i.keys.signingKey().id)
+	}
+}
+
+// Issue exchanges a token accepted by the provider for a signed ID token.
+func (i *Issuer) Issue(token string) (string, *Claims, error) {
+	userInfo, err := i.provider.Lookup(token)
+	if err != nil {
+		return "", nil, err
+	}
+	if userInfo == nil {
+		return "", nil, authentication.NewInvalidTokenError("token was not accepted")
+	}
+
+	subject := userInfo.UID
+	if subject == "" {
+		subject = userInfo.Username
+	}
+
+	now := time.Now()
+	claims := &Claims{
+		Issuer:            i.issuer,
+		Subject:           subject,
+		Audience:          i.clientID,
+		Expiry:            now.Add(i.tokenTTL).Unix(),
+		IssuedAt:          now.Unix(),
+		NotBefore:         now.Unix(),
+		PreferredUsername: userInfo.Username,
+		UID:               userInfo.UID,
+		Groups:            userInfo.Groups,
+	}
+	if claims.Groups == nil {
+		claims.Groups = []string{}
+	}
+
+	idToken, err := sign(i.keys.signingKey(), claims)
+	if err != nil {
+		return "", nil, err
+	}
+	return idToken, claims, nil
+}
+
+func (i *Issuer) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc(i.path+discoveryPath, i.serveDiscovery)
+	mux.HandleFunc(i.path+jwksPath, i.serveJWKS)
+	mux.HandleFunc(i.path+tokenPath, i.serveToken)
+}
+
+func (i *Issuer) serveDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, discovery{
+		Issuer:                           i.issuer,
+		JWKSURI:                          i.issuer + jwksPath,
+		TokenEndpoint:                    i.issuer + tokenPath,
+		ResponseTypesSupported:           []string{"id_token"},
+		SubjectTypesSupported:            []string{"public"},
+		IDTokenSigningAlgValuesSupported: []string{signingAlgorithm},
+		ClaimsSupported:                  []string{"iss", "sub", "aud", "exp", "iat", "nbf", "preferred_username", "uid", "groups"},
+	})
+}
+
+func (i *Issuer) serveJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Cache-Control", "public, max-age=60")
+	writeJSON(w, http.StatusOK, i.keys.jwks())
+}
+
+// serveToken exchanges the bearer token of the request, or the
+// subject_token form value, for an ID token.
+func (i *Issuer) serveToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		w.Header().Set("Allow", "POST")
+		writeJSON(w, http.StatusMethodNotAllowed, errorResponse{Error: "invalid_request"})
+		return
+	}
+
+	token := r.PostFormValue("subject_token")
+	if authorization := r.Header.Get("Authorization"); token == "" && strings.HasPrefix(authorization, "Bearer ") {
+		token = strings.TrimSpace(strings.TrimPrefix(authorization, "Bearer "))
+	}
+	if token == "" {
+		writeJSON(w, http.StatusBadRequest, errorResponse{
+			Error:            "invalid_request",
+			ErrorDescription: "missing bearer token or subject_token",
+		})
+		return
+	}
+
+	idToken, claims, err := i.Issue(token)
+	switch {
+	case err == nil:
+	case authentication.IsInvalidToken(err):
+		log.Debugf("Refused to issue ID token: %v", err)
+		writeJSON(w, http.StatusUnauthorized, errorResponse{Error: "invalid_grant"})
+		return
+	case authentication.IsUnavailable(err):
+		log.Errorf("Failed to issue ID token: %v", err)
+		writeJSON(w, http.StatusServiceUnavailable, errorResponse{Error: "temporarily_unavailable"})
+		return
+	default:
+		log.Errorf("Failed to issue ID token: %v", err)
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: "server_error"})
+		return
+	}
+
+	log.Debugf("Issued ID token for %s", claims.PreferredUsername)
+	w.Header().Set("Cache-Control", "no-store")
+	writeJSON(w, http.StatusOK, tokenResponse{
+		IDToken:   idToken,
+		TokenType: "Bearer",
+		ExpiresIn: claims.Expiry - claims.IssuedAt,
+	})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, value interface{}) {
+	response, err := json.Marshal(value)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte(err.Error()))
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	w.Write(response)
+}
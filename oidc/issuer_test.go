@@ -0,0 +1,170 @@
+package oidc
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	testauthentication "github.com/rancher/kubernetes-auth/authentication/test"
+)
+
+func newTestIssuer(t *testing.T) *Issuer {
+	issuer, err := NewIssuer("https://auth.example.com/oidc/", "kubernetes", time.Minute, time.Hour, &testauthentication.Provider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return issuer
+}
+
+func TestNewIssuer(t *testing.T) {
+	tests := []struct {
+		name                string
+		issuerURL           string
+		tokenTTL            time.Duration
+		keyRotationInterval time.Duration
+		valid               bool
+	}{
+		{"valid", "https://auth.example.com", time.Minute, time.Hour, true},
+		{"valid with path", "https://auth.example.com/oidc/", time.Minute, time.Hour, true},
+		{"http", "http://auth.example.com", time.Minute, time.Hour, false},
+		{"no scheme", "auth.example.com", time.Minute, time.Hour, false},
+		{"query", "https://auth.example.com?a=b", time.Minute, time.Hour, false},
+		{"no token TTL", "https://auth.example.com", 0, time.Hour, false},
+		{"no key rotation interval", "https://auth.example.com", time.Minute, 0, false},
+		{"negative key rotation interval", "https://auth.example.com", time.Minute, -time.Hour, false},
+	}
+
+	for _, test := range tests {
+		_, err := NewIssuer(test.issuerURL, "kubernetes", test.tokenTTL, test.keyRotationInterval, &testauthentication.Provider{})
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("%s: error %v, expected valid %v", test.name, err, test.valid)
+		}
+	}
+}
+
+func TestIssue(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	idToken, claims, err := issuer.Issue("test1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := parse(idToken, issuer.keys.publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	switch {
+	case parsed.Issuer != "https://auth.example.com/oidc":
+		t.Errorf("issuer %s", parsed.Issuer)
+	case parsed.Audience != "kubernetes":
+		t.Errorf("audience %s", parsed.Audience)
+	case parsed.Subject != "test1" || parsed.PreferredUsername != "test1":
+		t.Errorf("subject %s, username %s", parsed.Subject, parsed.PreferredUsername)
+	case parsed.Expiry-parsed.IssuedAt != 60:
+		t.Errorf("lifetime %ds", parsed.Expiry-parsed.IssuedAt)
+	case parsed.Groups == nil:
+		t.Error("groups missing")
+	case parsed.Expiry != claims.Expiry:
+		t.Errorf("returned expiry %d, signed %d", claims.Expiry, parsed.Expiry)
+	}
+
+	if _, _, err := issuer.Issue("unknown"); err == nil {
+		t.Error("issued an ID token for an unknown token")
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	issuer := newTestIssuer(t)
+
+	idToken, _, err := issuer.Issue("test1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := issuer.keys.rotate(issuer.tokenTTL); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := parse(idToken, issuer.keys.publicKey); err != nil {
+		t.Errorf("token signed with the retired key: %v", err)
+	}
+	if keys := issuer.keys.jwks().Keys; len(keys) != 2 {
+		t.Errorf("published %d keys, expected the current and the retired key", len(keys))
+	}
+
+	if err := issuer.keys.rotate(0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := parse(idToken, issuer.keys.publicKey); err == nil {
+		t.Error("token signed with an expired key still verifies")
+	}
+	if keys := issuer.keys.jwks().Keys; len(keys) != 2 {
+		t.Errorf("published %d keys after the first key expired, expected 2", len(keys))
+	}
+}
+
+func TestServeToken(t *testing.T) {
+	issuer := newTestIssuer(t)
+	mux := http.NewServeMux()
+	issuer.RegisterHandlers(mux)
+
+	tests := []struct {
+		name          string
+		method        string
+		authorization string
+		form          url.Values
+		status        int
+	}{
+		{"bearer token", "POST", "Bearer test1", nil, http.StatusOK},
+		{"subject token", "POST", "", url.Values{"subject_token": {"test2"}}, http.StatusOK},
+		{"unknown token", "POST", "Bearer unknown", nil, http.StatusUnauthorized},
+		{"no token", "POST", "", nil, http.StatusBadRequest},
+		{"get", "GET", "Bearer test1", nil, http.StatusMethodNotAllowed},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, "/oidc"+tokenPath, strings.NewReader(test.form.Encode()))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		w := httptest.NewRecorder()
+		mux.ServeHTTP(w, r)
+
+		if w.Code != test.status {
+			t.Errorf("%s: status %d, expected %d", test.name, w.Code, test.status)
+			continue
+		}
+		if test.status != http.StatusOK {
+			continue
+		}
+		var response tokenResponse
+		if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		if _, err := parse(response.IDToken, issuer.keys.publicKey); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+}
+
+func TestServeDiscovery(t *testing.T) {
+	issuer := newTestIssuer(t)
+	mux := http.NewServeMux()
+	issuer.RegisterHandlers(mux)
+
+	w := httptest.NewRecorder()
+	mux.ServeHTTP(w, httptest.NewRequest("GET", "/oidc"+discoveryPath, nil))
+
+	var d discovery
+	if err := json.Unmarshal(w.Body.Bytes(), &d); err != nil {
+		t.Fatal(err)
+	}
+	if d.Issuer != "https://auth.example.com/oidc" || d.JWKSURI != "https://auth.example.com/oidc"+jwksPath {
+		t.Errorf("got discovery %+v", d)
+	}
+}
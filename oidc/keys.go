@@ -0,0 +1,118 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"sync"
+	"time"
+)
+
+const keyBits = 2048
+
+type signingKey struct {
+	id         string
+	privateKey *rsa.PrivateKey
+	// retired is when the key stopped signing, verification keys are kept
+	// until every token they signed has expired
+	retired time.Time
+}
+
+type jsonWebKey struct {
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	KeyID     string `json:"kid"`
+	N         string `json:"n"`
+	E         string `json:"e"`
+}
+
+type jsonWebKeySet struct {
+	Keys []jsonWebKey `json:"keys"`
+}
+
+// keySet holds the current signing key and the retired keys that may still
+// have signed unexpired tokens.
+type keySet struct {
+	sync.RWMutex
+	current *signingKey
+	retired []*signingKey
+}
+
+func newSigningKey() (*signingKey, error) {
+	privateKey, err := rsa.GenerateKey(rand.Reader, keyBits)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&privateKey.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(der)
+
+	return &signingKey{
+		id:         base64.RawURLEncoding.EncodeToString(sum[:12]),
+		privateKey: privateKey,
+	}, nil
+}
+
+// rotate replaces the signing key and forgets retired keys that can no longer
+// have signed a valid token.
+func (k *keySet) rotate(tokenTTL time.Duration) error {
+	key, err := newSigningKey()
+	if err != nil {
+		return err
+	}
+
+	k.Lock()
+	defer k.Unlock()
+
+	now := time.Now()
+	var retired []*signingKey
+	for _, retiredKey := range k.retired {
+		if now.Sub(retiredKey.retired) < tokenTTL {
+			retired = append(retired, retiredKey)
+		}
+	}
+	if k.current != nil {
+		k.current.retired = now
+		retired = append(retired, k.current)
+	}
+
+	k.current = key
+	k.retired = retired
+	return nil
+}
+
+func (k *keySet) signingKey() *signingKey {
+	k.RLock()
+	defer k.RUnlock()
+	return k.current
+}
+
+func (k *keySet) jwks() jsonWebKeySet {
+	k.RLock()
+	defer k.RUnlock()
+
+	keySet := jsonWebKeySet{
+		Keys: []jsonWebKey{},
+	}
+	for _, key := range append([]*signingKey{k.current}, k.retired...) {
+		if key == nil {
+			continue
+		}
+		keySet.Keys = append(keySet.Keys, jsonWebKey{
+			KeyType:   "RSA",
+			Algorithm: signingAlgorithm,
+			Use:       "sig",
+			KeyID:     key.id,
+			N:         base64.RawURLEncoding.EncodeToString(key.privateKey.N.Bytes()),
+			E:         base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.privateKey.E)).Bytes()),
+		})
+	}
+	return keySet
+}
@@ -0,0 +1,129 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// parse checks the signature of a compact serialized token, as a client of
+// the issuer would, and returns its claims.
+func parse(token string, publicKey func(keyID string) *rsa.PublicKey) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("Malformed token")
+	}
+
+	headerJSON, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed token header: %v", err)
+	}
+	var h header
+	if err := json.Unmarshal(headerJSON, &h); err != nil {
+		return nil, fmt.Errorf("Malformed token header: %v", err)
+	}
+	if h.Algorithm != signingAlgorithm {
+		return nil, fmt.Errorf("Unsupported signing algorithm %s", h.Algorithm)
+	}
+
+	key := publicKey(h.KeyID)
+	if key == nil {
+		return nil, fmt.Errorf("Unknown signing key %s", h.KeyID)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed token signature: %v", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature); err != nil {
+		return nil, fmt.Errorf("Invalid token signature")
+	}
+
+	claimsJSON, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Malformed token claims: %v", err)
+	}
+	var claims Claims
+	if err := json.Unmarshal(claimsJSON, &claims); err != nil {
+		return nil, fmt.Errorf("Malformed token claims: %v", err)
+	}
+
+	return &claims, nil
+}
+
+// publicKey returns the verification key with the given ID, if it is still
+// published.
+func (k *keySet) publicKey(id string) *rsa.PublicKey {
+	k.RLock()
+	defer k.RUnlock()
+
+	for _, key := range append([]*signingKey{k.current}, k.retired...) {
+		if key != nil && key.id == id {
+			return &key.privateKey.PublicKey
+		}
+	}
+	return nil
+}
+
+func TestParse(t *testing.T) {
+	key, err := newSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := newSigningKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey := func(id string) *rsa.PublicKey {
+		if id == key.id {
+			return &key.privateKey.PublicKey
+		}
+		return nil
+	}
+
+	token, err := sign(key, &Claims{Subject: "user", Groups: []string{"group"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherToken, err := sign(other, &Claims{Subject: "user"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	encode := base64.RawURLEncoding.EncodeToString
+
+	tests := []struct {
+		name  string
+		token string
+		err   string
+	}{
+		{"valid", token, ""},
+		{"not a token", "token", "Malformed token"},
+		{"unknown key", otherToken, "Unknown signing key"},
+		{"claims changed", parts[0] + "." + encode([]byte(`{"sub":"admin"}`)) + "." + parts[2], "Invalid token signature"},
+		{"signature changed", parts[0] + "." + parts[1] + "." + encode([]byte("signature")), "Invalid token signature"},
+		{"no algorithm", encode([]byte(`{"alg":"none","kid":"`+key.id+`"}`)) + "." + parts[1] + ".", "Unsupported signing algorithm"},
+		{"header not base64", "!." + parts[1] + "." + parts[2], "Malformed token header"},
+	}
+
+	for _, test := range tests {
+		claims, err := parse(test.token, publicKey)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%s: %v", test.name, err)
+			} else if claims.Subject != "user" || len(claims.Groups) != 1 || claims.Groups[0] != "group" {
+				t.Errorf("%s: got claims %+v", test.name, claims)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%s: error %v, expected %s", test.name, err, test.err)
+		}
+	}
+}
@@ -19,6 +19,9 @@ if [ -n "$ACTION" ]; then
     curl -s -u $CATTLE_ACCESS_KEY:$CATTLE_SECRET_KEY -X POST $ACTION > certs.zip
     unzip -o certs.zip
 
-    TOKEN=$(cat /etc/kubernetes/ssl/key.pem | sha256sum | awk '{print $1}')
-    echo ${TOKEN} | exec "$@"
+    # The bootstrap token is derived from key.pem, and re-derived whenever
+    # the key is rotated, so it is not also passed on stdin where it would
+    # outlive the rotation
+    export BOOTSTRAP_KEY_FILE=${BOOTSTRAP_KEY_FILE:-/etc/kubernetes/ssl/key.pem}
+    exec "$@" </dev/null
 fi
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/ghodss/yaml"
+	"github.com/rancher/kubernetes-auth/authentication"
+	"github.com/rancher/kubernetes-auth/authentication/bootstrap"
+	_ "github.com/rancher/kubernetes-auth/authentication/exec"
+	"github.com/rancher/kubernetes-auth/authentication/rancher"
+	_ "github.com/rancher/kubernetes-auth/authentication/static"
+	_ "github.com/rancher/kubernetes-auth/authentication/test"
+	"github.com/rancher/kubernetes-auth/authentication/union"
+	"github.com/rancher/kubernetes-auth/authorization"
+	"github.com/rancher/kubernetes-auth/authorization/rancher"
+	"github.com/rancher/kubernetes-auth/healthcheck"
+	"github.com/urfave/cli"
+)
+
+// providerConfig is the format of the --provider-config file. Providers are
+// tried in order, each configured by the JSON or YAML under config that its
+// registered factory understands.
+type providerConfig struct {
+	Providers []providerEntry `json:"providers"`
+}
+
+type providerEntry struct {
+	Name    string          `json:"name"`
+	OnError string          `json:"onError,omitempty"`
+	Config  json.RawMessage `json:"config,omitempty"`
+}
+
+// newProvider configures the authentication provider from the global flags.
+// It also returns the first Rancher provider of the union, if there is one.
+func newProvider(c *cli.Context) (authentication.Provider, *rancherauthentication.Provider, error) {
+	defaultOnError, err := unionauthentication.ParseErrorPolicy(c.GlobalString("on-provider-error"))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	entries, err := providerEntries(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	bootstrapToken, err := readBootstrapToken()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var members []unionauthentication.Member
+	var rancher *rancherauthentication.Provider
+
+	for _, entry := range entries {
+		onError := defaultOnError
+		if entry.OnError != "" {
+			if onError, err = unionauthentication.ParseErrorPolicy(entry.OnError); err != nil {
+				return nil, nil, err
+			}
+		}
+
+		provider, err := authentication.New(entry.Name, entry.Config)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if rancherProvider, ok := provider.(*rancherauthentication.Provider); ok {
+			if c.GlobalString("evaluate-token") == "" {
+				go rancherProvider.SubscribeEvents()
+			}
+			healthcheck.Register(entry.Name, rancherProvider.Healthy)
+			if rancher == nil {
+				rancher = rancherProvider
+			}
+		}
+
+		log.Infof("Using authentication provider %s", entry.Name)
+		members = append(members, unionauthentication.Member{
+			Name:     entry.Name,
+			Provider: provider,
+			OnError:  onError,
+		})
+	}
+
+	// Bootstrap tokens stand in for a Rancher admin, so they are only
+	// accepted alongside a Rancher provider and get its admin group
+	if rancher != nil {
+		bootstrapProvider, err := newBootstrapProvider(c, bootstrapToken, rancher.AdminGroup())
+		if err != nil {
+			return nil, nil, err
+		}
+		if bootstrapProvider != nil {
+			members = append([]unionauthentication.Member{{
+				Name:     "bootstrap",
+				Provider: bootstrapProvider,
+				OnError:  defaultOnError,
+			}}, members...)
+		}
+	} else if bootstrapToken != "" {
+		log.Warn("Ignoring the bootstrap token, bootstrap tokens are only accepted with the rancher provider")
+	}
+
+	return unionauthentication.NewProvider(members...), rancher, nil
+}
+
+// newAuthorizer returns an authorizer for the environment roles of the
+// Rancher provider, or nil if there is none.
+func newAuthorizer(c *cli.Context, rancher *rancherauthentication.Provider) (authorization.Authorizer, error) {
+	if rancher == nil {
+		return nil, nil
+	}
+	policy := &authorization.DefaultPolicy
+	if policyFile := c.GlobalString("authorization-policy"); policyFile != "" {
+		var err error
+		if policy, err = authorization.LoadPolicy(policyFile); err != nil {
+			return nil, err
+		}
+	}
+	return rancherauthorization.NewAuthorizer(rancher, policy), nil
+}
+
+// readBootstrapToken reads the bootstrap token from stdin.
+func readBootstrapToken() (string, error) {
+	bytes, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		return "", err
+	}
+	bootstrapToken := strings.TrimSpace(string(bytes))
+
+	if bootstrapToken != "" {
+		log.Info("Bootstrap token read from stdin")
+		log.Debugf("Bootstrap token: %s", bootstrapToken)
+	}
+	return bootstrapToken, nil
+}
+
+// newBootstrapProvider returns a provider for the bootstrap token and any
+// bootstrap token files, giving them the admin group, or nil if there are
+// none.
+func newBootstrapProvider(c *cli.Context, bootstrapToken, adminGroup string) (*bootstrapauthentication.Provider, error) {
+	opts := bootstrapauthentication.Options{
+		Token:         bootstrapToken,
+		Path:          c.GlobalString("bootstrap-token-file"),
+		KeyFile:       c.GlobalString("bootstrap-key-file"),
+		Groups:        []string{adminGroup},
+		RotationGrace: c.GlobalDuration("bootstrap-rotation-grace"),
+	}
+	if opts.Token == "" && opts.Path == "" && opts.KeyFile == "" {
+		return nil, nil
+	}
+	return bootstrapauthentication.NewProvider(opts)
+}
+
+// providerEntries returns the providers listed in --provider-config, or else
+// the ones named with --provider configured from the flags. Without either,
+// the static provider is used when there is a token file, followed by the
+// test provider with --test-authentication or the Rancher provider.
+func providerEntries(c *cli.Context) ([]providerEntry, error) {
+	names := c.GlobalStringSlice("provider")
+
+	if configFile := c.GlobalString("provider-config"); configFile != "" {
+		if len(names) > 0 {
+			return nil, fmt.Errorf("Only one of --provider and --provider-config can be used")
+		}
+		data, err := ioutil.ReadFile(configFile)
+		if err != nil {
+			return nil, err
+		}
+		var config providerConfig
+		if err := yaml.Unmarshal(data, &config); err != nil {
+			return nil, fmt.Errorf("Failed to parse provider config %s: %v", configFile, err)
+		}
+		if len(config.Providers) == 0 {
+			return nil, fmt.Errorf("No providers listed in %s", configFile)
+		}
+		return config.Providers, nil
+	}
+
+	if len(names) == 0 {
+		if c.GlobalString("token-file") != "" {
+			names = append(names, "static")
+		}
+		if c.GlobalBool("test-authentication") {
+			names = append(names, "test")
+		} else {
+			names = append(names, "rancher")
+		}
+	}
+
+	var entries []providerEntry
+	for _, name := range names {
+		config, err := flagProviderConfig(c, name)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, providerEntry{
+			Name:   name,
+			Config: config,
+		})
+	}
+	return entries, nil
+}
+
+// flagProviderConfig builds the configuration of a built in provider from
+// the flags. Other providers get no configuration.
+func flagProviderConfig(c *cli.Context, name string) ([]byte, error) {
+	var config interface{}
+	switch name {
+	case "static":
+		config = map[string]interface{}{
+			"path": c.GlobalString("token-file"),
+		}
+	case "test":
+		config = map[string]interface{}{
+			"file": c.GlobalString("test-fixtures"),
+		}
+	case "rancher":
+		roleGroups, err := parseRoleGroups(c.GlobalStringSlice("role-group"))
+		if err != nil {
+			return nil, err
+		}
+		config = map[string]interface{}{
+			"environment":        c.GlobalString("rancher-environment"),
+			"adminGroup":         c.GlobalString("admin-group"),
+			"authDisabledPolicy": c.GlobalString("auth-disabled-policy"),
+			"authDisabledGroup":  c.GlobalString("auth-disabled-group"),
+			"roleGroups":         roleGroups,
+			"names": map[string]interface{}{
+				"usernameSource": c.GlobalString("username-source"),
+				"usernamePrefix": c.GlobalString("username-prefix"),
+				"groupPrefix":    c.GlobalString("group-prefix"),
+			},
+			"cacheSize":        c.GlobalInt("cache-size"),
+			"cacheTTL":         c.GlobalDuration("cache-ttl").String(),
+			"cacheNegativeTTL": c.GlobalDuration("cache-negative-ttl").String(),
+			"lookupTimeout":    c.GlobalDuration("lookup-timeout").String(),
+		}
+	default:
+		return nil, nil
+	}
+	return json.Marshal(config)
+}
+
+// parseRoleGroups parses role=group1,group2 mappings. A role mapped to
+// nothing gets no groups.
+func parseRoleGroups(mappings []string) (map[string][]string, error) {
+	roleGroups := map[string][]string{}
+	for _, mapping := range mappings {
+		parts := strings.SplitN(mapping, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, fmt.Errorf("Invalid role group mapping %s, expected role=group1,group2", mapping)
+		}
+		groups := []string{}
+		for _, group := range strings.Split(parts[1], ",") {
+			if group = strings.TrimSpace(group); group != "" {
+				groups = append(groups, group)
+			}
+		}
+		roleGroups[parts[0]] = groups
+	}
+	return roleGroups, nil
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/kubernetes-auth/healthcheck"
+	"github.com/rancher/kubernetes-auth/proxy"
+	"github.com/urfave/cli"
+)
+
+func proxyCommand() cli.Command {
+	return cli.Command{
+		Name:   "proxy",
+		Usage:  "Authenticate requests and forward them to the apiserver using impersonation",
+		Action: runProxy,
+		Flags: []cli.Flag{
+			cli.IntFlag{
+				Name:   "port",
+				Value:  6443,
+				Usage:  "Port to serve the proxy on",
+				EnvVar: "PROXY_PORT",
+			},
+			cli.StringFlag{
+				Name:   "tls-cert-file",
+				Usage:  "Certificate to serve the proxy with, the proxy serves plain HTTP without one",
+				EnvVar: "PROXY_TLS_CERT_FILE",
+			},
+			cli.StringFlag{
+				Name:   "tls-key-file",
+				Usage:  "Private key of the certificate to serve the proxy with",
+				EnvVar: "PROXY_TLS_KEY_FILE",
+			},
+			cli.StringFlag{
+				Name:   "upstream",
+				Value:  "https://kubernetes:6443",
+				Usage:  "URL of the apiserver to forward requests to",
+				EnvVar: "KUBERNETES_URL",
+			},
+			cli.StringFlag{
+				Name:   "upstream-ca-file",
+				Value:  "/etc/kubernetes/ssl/ca.pem",
+				Usage:  "CA used to verify the apiserver",
+				EnvVar: "UPSTREAM_CA_FILE",
+			},
+			cli.StringFlag{
+				Name:   "upstream-cert-file",
+				Usage:  "Client certificate the proxy authenticates to the apiserver with",
+				EnvVar: "UPSTREAM_CERT_FILE",
+			},
+			cli.StringFlag{
+				Name:   "upstream-key-file",
+				Usage:  "Private key of the client certificate",
+				EnvVar: "UPSTREAM_KEY_FILE",
+			},
+			cli.StringFlag{
+				Name:   "upstream-token-file",
+				Usage:  "File holding a bearer token the proxy authenticates to the apiserver with",
+				EnvVar: "UPSTREAM_TOKEN_FILE",
+			},
+		},
+	}
+}
+
+func runProxy(c *cli.Context) error {
+	provider, _, err := newProvider(c)
+	if err != nil {
+		return err
+	}
+
+	upstream, err := url.Parse(c.String("upstream"))
+	if err != nil {
+		return err
+	}
+
+	tlsConfig, err := proxy.NewTLSConfig(c.String("upstream-ca-file"), c.String("upstream-cert-file"), c.String("upstream-key-file"))
+	if err != nil {
+		return err
+	}
+
+	var bearerToken string
+	if tokenFile := c.String("upstream-token-file"); tokenFile != "" {
+		data, err := ioutil.ReadFile(tokenFile)
+		if err != nil {
+			return err
+		}
+		bearerToken = strings.TrimSpace(string(data))
+	}
+
+	resultChan := make(chan error)
+
+	go func(rc chan error) {
+		server := &http.Server{
+			Addr:    fmt.Sprintf(":%d", c.Int("port")),
+			Handler: proxy.New(provider, c.GlobalDuration("lookup-timeout"), upstream, tlsConfig, bearerToken),
+			// Connection upgrades for exec and port-forward need HTTP/1.1
+			TLSNextProto: map[string]func(*http.Server, *tls.Conn, http.Handler){},
+		}
+		log.Infof("Proxying %s on %s", upstream, server.Addr)
+		certFile, keyFile := c.String("tls-cert-file"), c.String("tls-key-file")
+		if certFile == "" {
+			log.Warn("Serving the proxy without TLS, bearer tokens will be sent in the clear")
+			rc <- server.ListenAndServe()
+		} else {
+			rc <- server.ListenAndServeTLS(certFile, keyFile)
+		}
+	}(resultChan)
+
+	go func(rc chan error) {
+		port := c.GlobalInt("health-check-port")
+		rc <- healthcheck.Start(port)
+	}(resultChan)
+
+	return <-resultChan
+}
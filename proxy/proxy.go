@@ -0,0 +1,286 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+
+	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
+
+	log "github.com/Sirupsen/logrus"
+	"github.com/rancher/kubernetes-auth/authentication"
+)
+
+const flushInterval = 100 * time.Millisecond
+
+// Proxy authenticates the bearer token of every request against a provider
+// and forwards it to the apiserver with its own credentials, impersonating
+// the authenticated user.
+type Proxy struct {
+	provider      authentication.Provider
+	lookupTimeout time.Duration
+	upstream      *url.URL
+	tlsConfig     *tls.Config
+	bearerToken   string
+	proxy         *httputil.ReverseProxy
+}
+
+// New creates a proxy to the upstream apiserver. Token lookups are given up
+// after the lookup timeout, if there is one. The TLS config carries the CA
+// and any client certificate used to reach the apiserver, the bearer token
+// is sent instead when set.
+func New(provider authentication.Provider, lookupTimeout time.Duration, upstream *url.URL, tlsConfig *tls.Config, bearerToken string) *Proxy {
+	p := &Proxy{
+		provider:      provider,
+		lookupTimeout: lookupTimeout,
+		upstream:      upstream,
+		tlsConfig:     tlsConfig,
+		bearerToken:   bearerToken,
+	}
+	p.proxy = &httputil.ReverseProxy{
+		Director: func(req *http.Request) {
+			req.URL.Scheme = upstream.Scheme
+			req.URL.Host = upstream.Host
+			req.URL.Path = singleJoiningSlash(upstream.Path, req.URL.Path)
+			req.Host = upstream.Host
+		},
+		Transport: &http.Transport{
+			Proxy:               http.ProxyFromEnvironment,
+			TLSClientConfig:     tlsConfig,
+			TLSHandshakeTimeout: 10 * time.Second,
+			MaxIdleConnsPerHost: 100,
+		},
+		// Flush continuously so that watches stream through the proxy
+		FlushInterval: flushInterval,
+	}
+	return p
+}
+
+func (p *Proxy) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	token := bearerToken(r)
+	if token == "" {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	ctx := r.Context()
+	if p.lookupTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.lookupTimeout)
+		defer cancel()
+	}
+
+	userInfo, err := authentication.LookupContext(ctx, p.provider, token)
+	if err != nil {
+		if authentication.IsUnavailable(err) {
+			log.Errorf("Failed to authenticate proxied request: %v", err)
+			http.Error(w, "Authentication backend unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		log.Debugf("Rejected proxied request: %v", err)
+		userInfo = nil
+	}
+	if userInfo == nil {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	req := new(http.Request)
+	*req = *r
+	req.Header = impersonationHeaders(r.Header, userInfo)
+	if p.bearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+p.bearerToken)
+	}
+
+	log.Debugf("Proxying %s %s as %s", r.Method, r.URL.Path, userInfo.Username)
+
+	if isUpgrade(req) {
+		p.serveUpgrade(w, req)
+		return
+	}
+	p.proxy.ServeHTTP(w, req)
+}
+
+// serveUpgrade tunnels connection upgrades, as used by exec, attach and
+// port-forward, by hijacking the client connection and splicing it to a
+// dedicated connection to the apiserver.
+func (p *Proxy) serveUpgrade(w http.ResponseWriter, req *http.Request) {
+	hijacker, ok := w.(http.Hijacker)
+	if !ok {
+		http.Error(w, "Connection upgrades are not supported", http.StatusInternalServerError)
+		return
+	}
+
+	backendConn, err := p.dial()
+	if err != nil {
+		log.Errorf("Failed to dial %s: %v", p.upstream.Host, err)
+		http.Error(w, "Failed to connect to the apiserver", http.StatusBadGateway)
+		return
+	}
+	defer backendConn.Close()
+
+	outReq := new(http.Request)
+	*outReq = *req
+	outReq.URL = &url.URL{
+		Path:     singleJoiningSlash(p.upstream.Path, req.URL.Path),
+		RawQuery: req.URL.RawQuery,
+	}
+	outReq.Host = p.upstream.Host
+	if err := outReq.Write(backendConn); err != nil {
+		log.Errorf("Failed to forward upgrade request: %v", err)
+		http.Error(w, "Failed to connect to the apiserver", http.StatusBadGateway)
+		return
+	}
+
+	clientConn, clientBuf, err := hijacker.Hijack()
+	if err != nil {
+		log.Errorf("Failed to hijack connection: %v", err)
+		return
+	}
+	defer clientConn.Close()
+
+	done := make(chan struct{}, 2)
+	go func() {
+		io.Copy(backendConn, clientBuf)
+		done <- struct{}{}
+	}()
+	go func() {
+		io.Copy(clientConn, bufio.NewReader(backendConn))
+		done <- struct{}{}
+	}()
+	<-done
+}
+
+func (p *Proxy) dial() (net.Conn, error) {
+	host := p.upstream.Host
+	if _, _, err := net.SplitHostPort(host); err != nil {
+		if p.upstream.Scheme == "https" {
+			host = net.JoinHostPort(host, "443")
+		} else {
+			host = net.JoinHostPort(host, "80")
+		}
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+	if p.upstream.Scheme != "https" {
+		return dialer.Dial("tcp", host)
+	}
+
+	tlsConfig := &tls.Config{}
+	if p.tlsConfig != nil {
+		tlsConfig = p.tlsConfig.Clone()
+	}
+	if tlsConfig.ServerName == "" {
+		tlsConfig.ServerName = p.upstream.Hostname()
+	}
+	return tls.DialWithDialer(dialer, "tcp", host, tlsConfig)
+}
+
+// impersonationHeaders copies the client headers, dropping its credentials
+// and any impersonation it asked for, and impersonates the given user.
+func impersonationHeaders(header http.Header, userInfo *k8sAuthentication.UserInfo) http.Header {
+	impersonated := http.Header{}
+	for key, values := range header {
+		if isCredentialHeader(key) {
+			continue
+		}
+		impersonated[key] = values
+	}
+
+	impersonated.Set(k8sAuthentication.ImpersonateUserHeader, userInfo.Username)
+	for _, group := range userInfo.Groups {
+		impersonated.Add(k8sAuthentication.ImpersonateGroupHeader, group)
+	}
+	for key, values := range userInfo.Extra {
+		for _, value := range values {
+			impersonated.Add(k8sAuthentication.ImpersonateUserExtraHeaderPrefix+url.PathEscape(key), value)
+		}
+	}
+
+	return impersonated
+}
+
+func isCredentialHeader(key string) bool {
+	key = http.CanonicalHeaderKey(key)
+	return key == "Authorization" ||
+		strings.HasPrefix(key, "Impersonate-")
+}
+
+func bearerToken(r *http.Request) string {
+	authorization := strings.TrimSpace(r.Header.Get("Authorization"))
+	parts := strings.SplitN(authorization, " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
+
+func isUpgrade(r *http.Request) bool {
+	for _, value := range r.Header["Connection"] {
+		for _, token := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func singleJoiningSlash(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
+
+// NewTLSConfig loads the CA used to verify the apiserver and an optional
+// client certificate to authenticate the proxy with.
+func NewTLSConfig(caFile, certFile, keyFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+
+	if caFile != "" {
+		pool, err := loadCertPool(caFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if certFile != "" || keyFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+func loadCertPool(caFile string) (*x509.CertPool, error) {
+	data, err := ioutil.ReadFile(caFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, fmt.Errorf("No certificates found in %s", caFile)
+	}
+	return pool, nil
+}
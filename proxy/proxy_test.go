@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/rancher/kubernetes-auth/authentication"
+	k8sAuthentication "k8s.io/client-go/pkg/apis/authentication"
+)
+
+type fakeProvider struct {
+	users map[string]*k8sAuthentication.UserInfo
+	err   error
+}
+
+func (f *fakeProvider) Lookup(token string) (*k8sAuthentication.UserInfo, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.users[token], nil
+}
+
+var testUser = &k8sAuthentication.UserInfo{
+	Username: "user",
+	Groups:   []string{"ldap_group:devs", "ldap_group:ops"},
+	Extra: map[string]k8sAuthentication.ExtraValue{
+		"rancher.io/identity-ids": {"ldap_user:user", "ldap_group:devs"},
+	},
+}
+
+func newTestProxy(t *testing.T, provider authentication.Provider, upstream *httptest.Server) *Proxy {
+	upstreamURL, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return New(provider, time.Minute, upstreamURL, nil, "proxy-token")
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		authorization string
+		token         string
+	}{
+		{"Bearer token", "token"},
+		{"bearer token", "token"},
+		{"  Bearer   token  ", "token"},
+		{"Basic dXNlcjpwYXNz", ""},
+		{"Bearer", ""},
+		{"token", ""},
+		{"", ""},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/api", nil)
+		r.Header.Set("Authorization", test.authorization)
+		if token := bearerToken(r); token != test.token {
+			t.Errorf("%q: got token %q, expected %q", test.authorization, token, test.token)
+		}
+	}
+}
+
+func TestIsCredentialHeader(t *testing.T) {
+	for key, expected := range map[string]bool{
+		"Authorization":            true,
+		"authorization":            true,
+		"Impersonate-User":         true,
+		"impersonate-group":        true,
+		"Impersonate-Extra-Scopes": true,
+		"Accept":                   false,
+		"X-Impersonate-User":       false,
+	} {
+		if isCredentialHeader(key) != expected {
+			t.Errorf("%s: expected %v", key, expected)
+		}
+	}
+}
+
+func TestProxyStatus(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer upstream.Close()
+
+	users := map[string]*k8sAuthentication.UserInfo{"valid": testUser}
+	tests := []struct {
+		name          string
+		provider      *fakeProvider
+		authorization string
+		status        int
+	}{
+		{"valid token", &fakeProvider{users: users}, "Bearer valid", http.StatusOK},
+		{"no token", &fakeProvider{users: users}, "", http.StatusUnauthorized},
+		{"not a bearer token", &fakeProvider{users: users}, "Basic dmFsaWQ=", http.StatusUnauthorized},
+		{"unknown token", &fakeProvider{users: users}, "Bearer unknown", http.StatusUnauthorized},
+		{"invalid token", &fakeProvider{err: authentication.NewInvalidTokenError("revoked")}, "Bearer valid", http.StatusUnauthorized},
+		{"backend unavailable", &fakeProvider{err: authentication.NewUnavailableError("backend", errors.New("down"))}, "Bearer valid", http.StatusServiceUnavailable},
+	}
+
+	for _, test := range tests {
+		p := newTestProxy(t, test.provider, upstream)
+		r := httptest.NewRequest(http.MethodGet, "/api", nil)
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+		recorder := httptest.NewRecorder()
+		p.ServeHTTP(recorder, r)
+		if recorder.Code != test.status {
+			t.Errorf("%s: status %d, expected %d", test.name, recorder.Code, test.status)
+		}
+	}
+}
+
+// checkImpersonation fails the test unless the upstream request carries the
+// proxy credentials and impersonates exactly the test user.
+func checkImpersonation(t *testing.T, header http.Header) {
+	if authorization := header.Get("Authorization"); authorization != "Bearer proxy-token" {
+		t.Errorf("upstream got Authorization %q", authorization)
+	}
+	if users := header[k8sAuthentication.ImpersonateUserHeader]; !reflect.DeepEqual(users, []string{"user"}) {
+		t.Errorf("upstream got users %v", users)
+	}
+	if groups := header[k8sAuthentication.ImpersonateGroupHeader]; !reflect.DeepEqual(groups, testUser.Groups) {
+		t.Errorf("upstream got groups %v, expected %v", groups, testUser.Groups)
+	}
+
+	extra := map[string][]string{}
+	for key, values := range header {
+		if !strings.HasPrefix(key, k8sAuthentication.ImpersonateUserExtraHeaderPrefix) {
+			continue
+		}
+		escaped := strings.TrimPrefix(key, k8sAuthentication.ImpersonateUserExtraHeaderPrefix)
+		if strings.Contains(escaped, "/") {
+			t.Errorf("extra header %s is not escaped", key)
+		}
+		name, err := url.PathUnescape(escaped)
+		if err != nil {
+			t.Errorf("extra header %s: %v", key, err)
+		}
+		extra[strings.ToLower(name)] = values
+	}
+	expected := map[string][]string{
+		"rancher.io/identity-ids": {"ldap_user:user", "ldap_group:devs"},
+	}
+	if !reflect.DeepEqual(extra, expected) {
+		t.Errorf("upstream got extra %v, expected %v", extra, expected)
+	}
+}
+
+func setClientImpersonation(header http.Header) {
+	header.Set("Authorization", "Bearer valid")
+	header.Set(k8sAuthentication.ImpersonateUserHeader, "admin")
+	header.Add(k8sAuthentication.ImpersonateGroupHeader, "system:masters")
+	header.Set(k8sAuthentication.ImpersonateUserExtraHeaderPrefix+"Scopes", "all")
+}
+
+func TestProxyImpersonation(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header
+		w.Write([]byte(r.URL.Path))
+	}))
+	defer upstream.Close()
+
+	p := newTestProxy(t, &fakeProvider{users: map[string]*k8sAuthentication.UserInfo{"valid": testUser}}, upstream)
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/namespaces", nil)
+	setClientImpersonation(r.Header)
+	r.Header.Set("Accept", "application/json")
+
+	recorder := httptest.NewRecorder()
+	p.ServeHTTP(recorder, r)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "/api/v1/namespaces" {
+		t.Fatalf("status %d, body %q", recorder.Code, recorder.Body.String())
+	}
+
+	header := <-headers
+	checkImpersonation(t, header)
+	if accept := header.Get("Accept"); accept != "application/json" {
+		t.Errorf("upstream got Accept %q", accept)
+	}
+}
+
+func TestProxyUpgrade(t *testing.T) {
+	headers := make(chan http.Header, 1)
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		headers <- r.Header
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		defer conn.Close()
+		buf.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: test\r\n\r\n")
+		buf.Flush()
+		io.Copy(conn, buf)
+	}))
+	defer upstream.Close()
+
+	p := newTestProxy(t, &fakeProvider{users: map[string]*k8sAuthentication.UserInfo{"valid": testUser}}, upstream)
+	server := httptest.NewServer(p)
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	r, err := http.NewRequest(http.MethodPost, server.URL+"/api/v1/namespaces/default/pods/p/exec", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	setClientImpersonation(r.Header)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "test")
+	if err := r.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+
+	reader := bufio.NewReader(conn)
+	response, err := http.ReadResponse(reader, r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("status %d, expected %d", response.StatusCode, http.StatusSwitchingProtocols)
+	}
+	checkImpersonation(t, <-headers)
+
+	if _, err := conn.Write([]byte("ping\n")); err != nil {
+		t.Fatal(err)
+	}
+	echo, err := reader.ReadString('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	if echo != "ping\n" {
+		t.Errorf("tunnel echoed %q", echo)
+	}
+}